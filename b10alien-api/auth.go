@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// subjectKey is the context key of the subject of the authenticated request
+const subjectKey = "subject"
+
+// apiKeyHeader carries the API key of the machine to machine callers
+const apiKeyHeader = "X-API-Key"
+
+// authMiddleware rejects the requests without a valid HS256 bearer token signed with the secret.
+// The subject of the token is stored in the context, so that the request logger can audit it.
+func authMiddleware(secret []byte) gin.HandlerFunc {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	keyFunc := func(*jwt.Token) (interface{}, error) {
+		return secret, nil
+	}
+	return func(c *gin.Context) {
+		raw, err := bearerToken(c.GetHeader("Authorization"))
+		if err == nil {
+			var claims jwt.RegisteredClaims
+			// the expiry is validated along with the signature
+			_, err = parser.ParseWithClaims(raw, &claims, keyFunc)
+			if err == nil {
+				c.Set(subjectKey, claims.Subject)
+				c.Next()
+				return
+			}
+		}
+		c.Header("WWW-Authenticate", `Bearer realm="b10alien-api"`)
+		abortWithError(c, http.StatusUnauthorized, "Unauthorized")
+	}
+}
+
+// apiKeyMiddleware rejects the requests without one of the API keys. The subject of a request is
+// the fingerprint of its key, so that the callers can be audited without logging the keys.
+func apiKeyMiddleware(keys []string) gin.HandlerFunc {
+	hashes := make([][sha256.Size]byte, len(keys))
+	for i, k := range keys {
+		hashes[i] = sha256.Sum256([]byte(k))
+	}
+	return func(c *gin.Context) {
+		key := c.GetHeader(apiKeyHeader)
+		h := sha256.Sum256([]byte(key))
+		// every key is compared in constant time, so the timing tells neither the key nor which matched
+		valid := 0
+		for i := range hashes {
+			valid |= subtle.ConstantTimeCompare(h[:], hashes[i][:])
+		}
+		if key == "" || valid == 0 {
+			abortWithError(c, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		c.Set(subjectKey, "api-key:"+hex.EncodeToString(h[:4]))
+		c.Next()
+	}
+}
+
+// jwtOrAPIKey authenticates the requests sending an API key with keyAuth, and the others with jwtAuth
+func jwtOrAPIKey(jwtAuth, keyAuth gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader(apiKeyHeader) != "" {
+			keyAuth(c)
+			return
+		}
+		jwtAuth(c)
+	}
+}
+
+// writeAuth returns the authentication of the writes, by the JWT signed with the secret and by the
+// API keys, or only by the one which is configured. At least one of the two is required.
+func writeAuth(secret string, keys []string) (gin.HandlerFunc, error) {
+	switch {
+	case secret != "" && len(keys) > 0:
+		return jwtOrAPIKey(authMiddleware([]byte(secret)), apiKeyMiddleware(keys)), nil
+	case secret != "":
+		return authMiddleware([]byte(secret)), nil
+	case len(keys) > 0:
+		return apiKeyMiddleware(keys), nil
+	}
+	return nil, errors.New("JWT_SECRET or API_KEYS must be set")
+}
+
+// loadAPIKeys returns the comma separated keys of list followed by the keys of the file, one per
+// line. The blank keys are skipped and the file is optional.
+func loadAPIKeys(list, file string) ([]string, error) {
+	raw := strings.Split(list, ",")
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, strings.Split(string(b), "\n")...)
+	}
+	var keys []string
+	for _, k := range raw {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// bearerToken returns the token of the Authorization header
+func bearerToken(header string) (string, error) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", errors.New("missing bearer token")
+	}
+	return token, nil
+}
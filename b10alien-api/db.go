@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/keploy/go-sdk/integrations/kmongo"
+	"github.com/keploy/go-sdk/keploy"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// ErrAlienNotFound is returned when no alien matches the given id.
+var ErrAlienNotFound = errors.New("alien not found")
+
+// ErrVersionConflict is returned when the stored alien isn't at the expected version anymore.
+var ErrVersionConflict = errors.New("version conflict")
+
+// mongoInitialBackoff is the wait before the second connection attempt, it doubles on every attempt.
+const mongoInitialBackoff = 500 * time.Millisecond
+
+// connectMongo connects to mongo and pings it. When mongo isn't ready yet, eg: when it is started
+// along the app, the connection is retried up to attempts times with an exponential backoff capped
+// at maxBackoff.
+func connectMongo(ctx context.Context, uri string, attempts int, maxBackoff time.Duration) (*mongo.Client, error) {
+	backoff := mongoInitialBackoff
+	for i := 1; ; i++ {
+		logger.Info("connecting to mongo", zap.Int("attempt", i), zap.Int("max_attempts", attempts))
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+		if err == nil && keploy.GetMode() == keploy.MODE_TEST {
+			// the queries are mocked by keploy, mongo doesn't have to be running
+			return client, nil
+		}
+		if err == nil {
+			pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			err = client.Ping(pingCtx, nil)
+			cancel()
+			if err == nil {
+				return client, nil
+			}
+			client.Disconnect(ctx)
+		}
+		if i >= attempts {
+			return nil, err
+		}
+		logger.Warn("failed to connect to mongo, retrying", zap.Int("attempt", i), zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func NewAlienDB(c *kmongo.Collection) *AlienDB {
+	return &AlienDB{c: c}
+}
+
+// AlienDB stores the aliens in a mongo collection. The collection is wrapped by kmongo
+// so that the queries are captured and mocked by keploy.
+type AlienDB struct {
+	c *kmongo.Collection
+}
+
+// GetAll returns the aliens matching the filter in insertion order.
+func (a *AlienDB) GetAll(ctx context.Context, filter bson.M) ([]b10alien, error) {
+	cur, err := a.c.Find(ctx, filter, options.Find())
+	if err != nil {
+		return nil, err
+	}
+	res := []b10alien{}
+	if err = cur.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Get returns the alien with the given id, including the deleted ones.
+func (a *AlienDB) Get(ctx context.Context, id string) (b10alien, error) {
+	var alien b10alien
+	err := a.c.FindOne(ctx, bson.M{"_id": id}).Decode(&alien)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return alien, ErrAlienNotFound
+	}
+	return alien, err
+}
+
+// First returns the first alien matching the filter in the given order,
+// ErrAlienNotFound is returned if none matches.
+func (a *AlienDB) First(ctx context.Context, filter bson.M, sort bson.D) (b10alien, error) {
+	var alien b10alien
+	err := a.c.FindOne(ctx, filter, options.FindOne().SetSort(sort)).Decode(&alien)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return alien, ErrAlienNotFound
+	}
+	return alien, err
+}
+
+func (a *AlienDB) Upsert(ctx context.Context, alien b10alien) error {
+	upsert := true
+	opt := &options.UpdateOptions{
+		Upsert: &upsert,
+	}
+	_, err := a.c.UpdateOne(ctx, bson.M{"_id": alien.ID}, bson.M{"$set": alien}, opt)
+	return err
+}
+
+// Replace writes the alien over the stored one only if the stored one is still at the given
+// version, so that a write which wasn't seen is never overwritten. ErrVersionConflict is returned
+// otherwise.
+func (a *AlienDB) Replace(ctx context.Context, alien b10alien, version int64) error {
+	res, err := a.c.UpdateOne(ctx, bson.M{"_id": alien.ID, "version": version}, bson.M{"$set": alien})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// Delete marks the alien with the given id as deleted, so that it can be restored later.
+// ErrAlienNotFound is returned if it doesn't exist or is already deleted. The caller must hold
+// b10aliensMu, like for every write.
+func (a *AlienDB) Delete(ctx context.Context, id string) error {
+	return a.setDeleted(ctx, id, true)
+}
+
+// Restore clears the deleted mark of the alien with the given id.
+// ErrAlienNotFound is returned if it doesn't exist or isn't deleted.
+func (a *AlienDB) Restore(ctx context.Context, id string) error {
+	return a.setDeleted(ctx, id, false)
+}
+
+func (a *AlienDB) setDeleted(ctx context.Context, id string, deleted bool) error {
+	update := bson.M{
+		"$set": bson.M{"deleted": deleted, "updated_at": time.Now().UnixMilli()},
+		"$inc": bson.M{"version": 1},
+	}
+	res, err := a.c.UpdateOne(ctx, bson.M{"_id": id, "deleted": bson.M{"$ne": deleted}}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrAlienNotFound
+	}
+	return nil
+}
+
+// Count returns the number of aliens matching the filter.
+func (a *AlienDB) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return a.c.CountDocuments(ctx, filter)
+}
+
+// Seed inserts the given aliens if the collection is empty.
+func (a *AlienDB) Seed(ctx context.Context, aliens []b10alien) error {
+	count, err := a.Count(ctx, bson.M{})
+	if err != nil || count > 0 {
+		return err
+	}
+	for _, alien := range aliens {
+		if err = a.Upsert(ctx, alien); err != nil {
+			return err
+		}
+	}
+	return nil
+}
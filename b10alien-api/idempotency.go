@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotencyKeyHeader lets the clients retry a create safely, the retries sending the same key
+// get the response of the first request instead of creating again
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentReplayedHeader marks the responses replayed for a reused key
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLen bounds the keys held in memory
+const maxIdempotencyKeyLen = 255
+
+// idempotentResponse is the response of the first request sent with a key. It is pending until
+// that request completes.
+type idempotentResponse struct {
+	// fingerprint is the hash of the request body, a key can't be reused for another body
+	fingerprint [sha256.Size]byte
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	s := &idempotencyStore{
+		ttl:       ttl,
+		responses: map[string]*idempotentResponse{},
+	}
+	go func() {
+		for now := range time.Tick(ttl) {
+			s.cleanup(now)
+		}
+	}()
+	return s
+}
+
+// idempotencyStore holds the responses of the requests sent with an idempotency key for the ttl.
+type idempotencyStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	responses map[string]*idempotentResponse
+}
+
+// begin reserves the key for a request. It returns the response stored for the key if it was
+// already used, the response is pending while the first request is in flight.
+func (s *idempotencyStore) begin(key string, fingerprint [sha256.Size]byte, now time.Time) *idempotentResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.responses[key]; ok && now.Before(r.expires) {
+		// a copy, so that the caller reads it without the lock
+		res := *r
+		return &res
+	}
+	s.responses[key] = &idempotentResponse{fingerprint: fingerprint, expires: now.Add(s.ttl)}
+	return nil
+}
+
+// finish stores the response of the request which reserved the key. The server errors aren't
+// stored, so that the key can be retried.
+func (s *idempotencyStore) finish(key string, status int, contentType string, body []byte, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.responses[key]
+	if !ok {
+		return
+	}
+	if status >= http.StatusInternalServerError {
+		delete(s.responses, key)
+		return
+	}
+	r.done = true
+	r.status = status
+	r.contentType = contentType
+	r.body = body
+	r.expires = now.Add(s.ttl)
+}
+
+// release frees the key of a request which didn't complete, eg: when its handler panicked.
+func (s *idempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.responses[key]; ok && !r.done {
+		delete(s.responses, key)
+	}
+}
+
+// cleanup removes the expired responses.
+func (s *idempotencyStore) cleanup(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, r := range s.responses {
+		if !now.Before(r.expires) {
+			delete(s.responses, k)
+		}
+	}
+}
+
+// bodyRecorder copies the response body, so that it can be stored.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyMiddleware replays the stored response of a request retried with the same
+// Idempotency-Key. The keys are scoped to the authenticated subject, the requests without a key
+// are handled as usual.
+func idempotencyMiddleware(s *idempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			abortWithError(c, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if isBodyTooLarge(err) {
+			abortWithError(c, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+			return
+		}
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, "failed to read the request body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256(body)
+
+		key = c.GetString(subjectKey) + ":" + key
+		stored := s.begin(key, fingerprint, time.Now())
+		switch {
+		case stored == nil:
+		case stored.fingerprint != fingerprint:
+			abortWithError(c, http.StatusUnprocessableEntity, "Idempotency-Key was used with another request body")
+			return
+		case !stored.done:
+			abortWithError(c, http.StatusConflict, "a request with the same Idempotency-Key is in progress")
+			return
+		default:
+			c.Header(idempotentReplayedHeader, "true")
+			c.Data(stored.status, stored.contentType, stored.body)
+			c.Abort()
+			return
+		}
+
+		w := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = w
+		finished := false
+		defer func() {
+			if !finished {
+				s.release(key)
+			}
+		}()
+		c.Next()
+		s.finish(key, w.Status(), w.Header().Get("Content-Type"), w.body.Bytes(), time.Now())
+		finished = true
+	}
+}
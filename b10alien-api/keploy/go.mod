@@ -1,6 +1,6 @@
 module go.keploy.io/server
 
-go 1.17
+go 1.18
 
 // replace github.com/keploy/go-sdk => ../go-sdk
 
@@ -21,6 +21,7 @@ require (
 
 require (
 	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/google/go-cmp v0.5.5 // indirect
 	golang.org/x/net v0.0.0-20220225172249-27dd8689420f // indirect
 	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
 )
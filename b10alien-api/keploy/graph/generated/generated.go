@@ -1084,6 +1084,7 @@ type JSONError {
 enum BodyType {
   PLAIN
   JSON
+  XML
 }
 
 type Kv {
@@ -185,16 +185,18 @@ type BodyType string
 const (
 	BodyTypePlain BodyType = "PLAIN"
 	BodyTypeJSON  BodyType = "JSON"
+	BodyTypeXML   BodyType = "XML"
 )
 
 var AllBodyType = []BodyType{
 	BodyTypePlain,
 	BodyTypeJSON,
+	BodyTypeXML,
 }
 
 func (e BodyType) IsValid() bool {
 	switch e {
-	case BodyTypePlain, BodyTypeJSON:
+	case BodyTypePlain, BodyTypeJSON, BodyTypeXML:
 		return true
 	}
 	return false
@@ -153,7 +153,7 @@ func (r *queryResolver) TestCase(ctx context.Context, app *string, id *string, o
 		return []*model.TestCase{ConvertTestCase(tc)}, nil
 	}
 
-	tcs, err := r.reg.GetAll(ctx, DEFAULT_COMPANY, a, offset, limit)
+	tcs, err := r.reg.GetAll(ctx, DEFAULT_COMPANY, a, nil, offset, limit)
 	if err != nil {
 		return nil, err
 	}
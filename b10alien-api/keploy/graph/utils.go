@@ -118,6 +118,8 @@ func ConvertBodyType(b run.BodyType) model.BodyType {
 	switch b {
 	case run.BodyTypeJSON:
 		return model.BodyTypeJSON
+	case run.BodyTypeXML:
+		return model.BodyTypeXML
 	default:
 		return model.BodyTypePlain
 	}
@@ -136,11 +138,24 @@ func ConvertResult(r run.Result) *model.Result {
 			Type:     ConvertBodyType(r.BodyResult.Type),
 			Expected: r.BodyResult.Expected,
 			Actual:   r.BodyResult.Actual,
+			Errors:   ConvertBodyDiffs(r.BodyResult.Diffs),
 		},
 		DepResult: nil,
 	}
 }
 
+func ConvertBodyDiffs(d []run.BodyDiff) []*model.JSONError {
+	var errs []*model.JSONError
+	for _, v := range d {
+		errs = append(errs, &model.JSONError{
+			Key:               v.Key,
+			MissingInExpected: v.Expected == nil,
+			MissingInActual:   v.Actual == nil,
+		})
+	}
+	return errs
+}
+
 func ConvertHeaderInput(h []*model.HeaderInput) http.Header {
 	headers := http.Header{}
 	for _, v := range h {
@@ -199,7 +199,7 @@ func (srv *Server) GetTCS(ctx context.Context, request *proto.GetTCSRequest) (*p
 			srv.logger.Error("request for fetching testcases in converting limit to integer")
 		}
 	}
-	tcs, err := srv.svc.GetAll(ctx, graph.DEFAULT_COMPANY, app, &offset, &limit)
+	tcs, err := srv.svc.GetAll(ctx, graph.DEFAULT_COMPANY, app, nil, &offset, &limit)
 	if err != nil {
 		return nil, err
 	}
@@ -264,12 +264,17 @@ func (srv *Server) PostTC(ctx context.Context, request *proto.TestCaseReq) (*pro
 		srv.logger.Error("error putting testcase", zap.Error(err))
 		return nil, err
 	}
-	if len(inserted) == 0 {
+	if len(inserted.Inserted)+len(inserted.Duplicates) == 0 {
 		srv.logger.Error("unknown failure while inserting testcase")
 		return nil, err
 	}
+	// the id of a duplicate testcase is empty
+	id := ""
+	if len(inserted.Inserted) > 0 {
+		id = inserted.Inserted[0]
+	}
 	return &proto.PostTCResponse{
-		TcsId: map[string]string{"id": inserted[0]},
+		TcsId: map[string]string{"id": id},
 	}, nil
 }
 
@@ -25,11 +25,20 @@ func New(r chi.Router, logger *zap.Logger, svc regression2.Service, run run.Serv
 			r.Get("/{id}", s.GetTC)
 			r.Get("/", s.GetTCS)
 			r.Post("/", s.PostTC)
+			r.Delete("/", s.DeleteTCs)
 		})
 		r.Post("/test", s.Test)
 		r.Post("/denoise", s.DeNoise)
+		r.Post("/denoise/samples", s.DeNoiseSamples)
+		r.Post("/denoise/request", s.DeNoiseRequest)
+		r.Get("/noise", s.NoisyFields)
+		r.Delete("/cache", s.ResetCache)
 		r.Get("/start", s.Start)
 		r.Get("/end", s.End)
+		r.Get("/testrun/{id}/junit", s.JUnit)
+		r.Post("/testrun/{id}/retry", s.RetryFailed)
+		r.Delete("/testrun/{id}", s.DeleteRun)
+		r.Delete("/testrun", s.DeleteRunsBefore)
 
 		//r.Get("/search", searchArticles)                                  // GET /articles/search
 	})
@@ -64,6 +73,60 @@ func (rg *regression) End(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// JUnit writes the results of a test run as a JUnit XML report.
+func (rg *regression) JUnit(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	report, err := rg.run.JUnit(r.Context(), graph.DEFAULT_COMPANY, id)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(report)
+}
+
+// RetryFailed replays the failed testcases of the given test run against the target query param,
+// under a child run of the test run.
+func (rg *regression) RetryFailed(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	res, err := rg.run.RetryFailed(r.Context(), graph.DEFAULT_COMPANY, id, r.URL.Query().Get("target"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, res)
+}
+
+// DeleteRun deletes the given test run and its tests.
+func (rg *regression) DeleteRun(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	count, err := rg.run.DeleteRun(r.Context(), graph.DEFAULT_COMPANY, id)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, map[string]int64{"deleted_tests": count})
+}
+
+// DeleteRunsBefore deletes the test runs created before the given unix timestamp and their tests.
+func (rg *regression) DeleteRunsBefore(w http.ResponseWriter, r *http.Request) {
+	before, err := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("before must be a unix timestamp")))
+		return
+	}
+	runs, tests, err := rg.run.DeleteBefore(r.Context(), graph.DEFAULT_COMPANY, time.Unix(before, 0))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, map[string]int64{"deleted_runs": runs, "deleted_tests": tests})
+}
+
 func (rg *regression) Start(w http.ResponseWriter, r *http.Request) {
 	t := r.URL.Query().Get("total")
 	total, err := strconv.Atoi(t)
@@ -106,6 +169,10 @@ func (rg *regression) GetTC(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	app := rg.getMeta(w, r, false)
 	tcs, err := rg.svc.Get(r.Context(), graph.DEFAULT_COMPANY, app, id)
+	if errors.Is(err, regression2.ErrTestCaseNotFound) {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
@@ -115,6 +182,54 @@ func (rg *regression) GetTC(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// NoisyFields lists the request fields ignored by the deduplication for the given app, method and uri.
+func (rg *regression) NoisyFields(w http.ResponseWriter, r *http.Request) {
+	app := rg.getMeta(w, r, true)
+	if app == "" {
+		return
+	}
+	uri := r.URL.Query().Get("uri")
+	if uri == "" {
+		render.Render(w, r, ErrInvalidRequest(errors.New("missing uri")))
+		return
+	}
+	method := models.Method(r.URL.Query().Get("method"))
+	fields, err := rg.svc.NoisyFields(r.Context(), graph.DEFAULT_COMPANY, app, method, uri)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, fields)
+}
+
+// ResetCache drops the deduplication cache of the app.
+func (rg *regression) ResetCache(w http.ResponseWriter, r *http.Request) {
+	app := rg.getMeta(w, r, true)
+	if app == "" {
+		return
+	}
+	rg.svc.ResetCache(graph.DEFAULT_COMPANY, app)
+	render.Status(r, http.StatusOK)
+}
+
+// DeleteTCs deletes a batch of testcases and reports the outcome of every deletion.
+func (rg *regression) DeleteTCs(w http.ResponseWriter, r *http.Request) {
+	data := &DeleteTCsReq{}
+	if err := render.Bind(r, data); err != nil {
+		rg.logger.Error("error parsing request", zap.Error(err))
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	res, err := rg.svc.DeleteTCs(r.Context(), graph.DEFAULT_COMPANY, data.IDs)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, res)
+}
+
 func (rg *regression) getMeta(w http.ResponseWriter, r *http.Request, appRequired bool) string {
 	app := r.URL.Query().Get("app")
 	if app == "" && appRequired {
@@ -149,7 +264,8 @@ func (rg *regression) GetTCS(w http.ResponseWriter, r *http.Request) {
 			rg.logger.Error("request for fetching testcases in converting limit to integer")
 		}
 	}
-	tcs, err := rg.svc.GetAll(r.Context(), graph.DEFAULT_COMPANY, app, &offset, &limit)
+	// eg: ?tag=smoke&tag=auth returns the testcases tagged with both
+	tcs, err := rg.svc.GetAll(r.Context(), graph.DEFAULT_COMPANY, app, r.URL.Query()["tag"], &offset, &limit)
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
@@ -177,7 +293,7 @@ func (rg *regression) PostTC(w http.ResponseWriter, r *http.Request) {
 	// rg.logger.Debug("testcase posted",zap.Any("testcase request",data))
 
 	now := time.Now().UTC().Unix()
-	inserted, err := rg.svc.Put(r.Context(), graph.DEFAULT_COMPANY, []models.TestCase{{
+	tcs := []models.TestCase{{
 		ID:       uuid.New().String(),
 		Created:  now,
 		Updated:  now,
@@ -187,7 +303,22 @@ func (rg *regression) PostTC(w http.ResponseWriter, r *http.Request) {
 		HttpReq:  data.HttpReq,
 		HttpResp: data.HttpResp,
 		Deps:     data.Deps,
-	}})
+		Tags:     data.Tags,
+	}}
+
+	// ?dry_run=true reports whether the testcase is a duplicate without saving it
+	if r.URL.Query().Get("dry_run") == "true" {
+		reports, err := rg.svc.DryRunPut(r.Context(), graph.DEFAULT_COMPANY, tcs)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, reports)
+		return
+	}
+
+	inserted, err := rg.svc.Put(r.Context(), graph.DEFAULT_COMPANY, tcs)
 	if err != nil {
 		rg.logger.Error("error putting testcase", zap.Error(err))
 		render.Render(w, r, ErrInvalidRequest(err))
@@ -196,14 +327,19 @@ func (rg *regression) PostTC(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// rg.logger.Debug("testcase inserted",zap.Any("testcase ids",inserted))
-	if len(inserted) == 0 {
+	if len(inserted.Inserted)+len(inserted.Duplicates) == 0 {
 		rg.logger.Error("unknown failure while inserting testcase")
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
+	// the id of a duplicate testcase is empty
+	id := ""
+	if len(inserted.Inserted) > 0 {
+		id = inserted.Inserted[0]
+	}
 	render.Status(r, http.StatusOK)
-	render.JSON(w, r, map[string]string{"id": inserted[0]})
+	render.JSON(w, r, map[string]string{"id": id})
 
 }
 
@@ -233,6 +369,43 @@ func (rg *regression) DeNoise(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func (rg *regression) DeNoiseRequest(w http.ResponseWriter, r *http.Request) {
+	data := &DeNoiseRequestReq{}
+	if err := render.Bind(r, data); err != nil {
+		rg.logger.Error("error parsing request", zap.Error(err))
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	err := rg.svc.DeNoiseRequest(r.Context(), graph.DEFAULT_COMPANY, data.ID, data.AppID, data.Req)
+	if err != nil {
+		rg.logger.Error("error denoising testcase request", zap.Error(err))
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+}
+
+func (rg *regression) DeNoiseSamples(w http.ResponseWriter, r *http.Request) {
+	data := &DeNoiseSamplesReq{}
+	if err := render.Bind(r, data); err != nil {
+		rg.logger.Error("error parsing request", zap.Error(err))
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	err := rg.svc.DeNoiseSamples(r.Context(), graph.DEFAULT_COMPANY, data.ID, data.AppID, data.Samples)
+	if err != nil {
+		rg.logger.Error("error denoising testcase", zap.Error(err))
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+
+}
+
 func (rg *regression) Test(w http.ResponseWriter, r *http.Request) {
 
 	data := &TestReq{}
@@ -16,6 +16,7 @@ type TestCaseReq struct {
 	HttpReq  models.HttpReq      `json:"http_req" bson:"http_req"`
 	HttpResp models.HttpResp     `json:"http_resp" bson:"http_resp"`
 	Deps     []models.Dependency `json:"deps" bson:"deps"`
+	Tags     []string            `json:"tags" bson:"tags"`
 }
 
 func (req *TestCaseReq) Bind(r *http.Request) error {
@@ -48,3 +49,58 @@ func (req *TestReq) Bind(r *http.Request) error {
 
 	return nil
 }
+
+// DeNoiseSamplesReq holds several responses of a testcase, captured by replaying it
+type DeNoiseSamplesReq struct {
+	ID      string            `json:"id" bson:"_id"`
+	AppID   string            `json:"app_id" bson:"app_id"`
+	Samples []models.HttpResp `json:"samples" bson:"samples"`
+}
+
+// DeleteTCsReq holds the ids of the testcases to delete
+type DeleteTCsReq struct {
+	IDs []string `json:"ids" bson:"ids"`
+}
+
+func (req *DeleteTCsReq) Bind(r *http.Request) error {
+	if len(req.IDs) == 0 {
+		return errors.New("ids are required")
+	}
+
+	return nil
+}
+
+// DeNoiseRequestReq holds another request of a testcase, to find its volatile fields
+type DeNoiseRequestReq struct {
+	ID    string         `json:"id" bson:"_id"`
+	AppID string         `json:"app_id" bson:"app_id"`
+	Req   models.HttpReq `json:"req" bson:"req"`
+}
+
+func (req *DeNoiseRequestReq) Bind(r *http.Request) error {
+	if req.ID == "" {
+		return errors.New("id is required")
+	}
+
+	if req.AppID == "" {
+		return errors.New("app id is required")
+	}
+
+	return nil
+}
+
+func (req *DeNoiseSamplesReq) Bind(r *http.Request) error {
+	if req.ID == "" {
+		return errors.New("id is required")
+	}
+
+	if req.AppID == "" {
+		return errors.New("app id is required")
+	}
+
+	if len(req.Samples) == 0 {
+		return errors.New("samples are required")
+	}
+
+	return nil
+}
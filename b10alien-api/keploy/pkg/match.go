@@ -3,7 +3,9 @@ package pkg
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"go.uber.org/zap"
@@ -33,7 +35,81 @@ func convertJson(s string, log *zap.Logger) (interface{}, error) {
 
 }
 
+// Match compares the expected and actual JSON strings, ignoring the noisy fields. Every expected
+// array element is looked up anywhere in the actual array, so neither the order of the elements
+// nor how often they repeat is checked.
 func Match(exp, act string, noise []string, log *zap.Logger) (bool, error) {
+	return match(exp, act, noise, MatchOptions{}, log)
+}
+
+// MatchUnordered compares the expected and actual JSON strings like Match, except that the arrays
+// are compared as multisets: the arrays match if they hold the same elements, the same number
+// of times, in any order.
+func MatchUnordered(exp, act string, noise []string, log *zap.Logger) (bool, error) {
+	return MatchWithOptions(exp, act, noise, MatchOptions{UnorderedArrays: true}, log)
+}
+
+// DefaultMaxDepth is the deepest nesting of the compared JSON and XML documents when no max depth
+// is given.
+const DefaultMaxDepth = 100
+
+// ErrMaxDepth is returned when a JSON or XML document is nested deeper than the max depth, so that
+// a crafted document can't exhaust the stack of the recursive comparison.
+var ErrMaxDepth = errors.New("document nested too deep")
+
+// CheckDepth returns ErrMaxDepth if the decoded JSON document is nested deeper than max, a scalar
+// being at depth 0. It stops at max, so it never recurses deeper itself.
+func CheckDepth(v interface{}, max int) error {
+	if max < 0 {
+		return ErrMaxDepth
+	}
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for _, e := range x {
+			if err := CheckDepth(e, max-1); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, e := range x {
+			if err := CheckDepth(e, max-1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MatchOptions relaxes the comparison of Match.
+type MatchOptions struct {
+	// UnorderedArrays compares the arrays as multisets, like MatchUnordered
+	UnorderedArrays bool
+	// Partial ignores the object keys that are only in the actual JSON, at every level
+	Partial bool
+	// AbsTolerance and RelTolerance are the absolute and relative differences allowed between two
+	// numbers, the numbers match if they are within either of them. Zero means an exact match.
+	AbsTolerance float64
+	RelTolerance float64
+	// MaxDepth is the deepest nesting of the documents, ErrMaxDepth is returned for the deeper
+	// ones. Zero means DefaultMaxDepth.
+	MaxDepth int
+}
+
+// MatchWithOptions compares the expected and actual JSON strings like Match, relaxed by opts.
+func MatchWithOptions(exp, act string, noise []string, opts MatchOptions, log *zap.Logger) (bool, error) {
+	return match(exp, act, noise, opts, log)
+}
+
+func checkDepths(max int, docs ...interface{}) error {
+	for _, d := range docs {
+		if err := CheckDepth(d, max); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func match(exp, act string, noise []string, opts MatchOptions, log *zap.Logger) (bool, error) {
 
 	noiseMap := convertToMap(noise)
 	expected, err := convertJson(exp, log)
@@ -44,6 +120,13 @@ func Match(exp, act string, noise []string, log *zap.Logger) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	max := opts.MaxDepth
+	if max == 0 {
+		max = DefaultMaxDepth
+	}
+	if err = checkDepths(max, expected, actual); err != nil {
+		return false, err
+	}
 	if reflect.TypeOf(expected) != reflect.TypeOf(actual) {
 		return false, nil
 	}
@@ -52,7 +135,7 @@ func Match(exp, act string, noise []string, log *zap.Logger) (bool, error) {
 
 	tmp = mapClone(noiseMap)
 	actual = removeNoisy(actual, tmp)
-	return jsonMatch(expected, actual)
+	return jsonCompare(expected, actual, opts)
 
 }
 
@@ -63,6 +146,8 @@ func removeNoisy(element interface{}, noise map[string][]string) interface{} {
 	switch y.Kind() {
 	case reflect.Map:
 		el := element.(map[string]interface{})
+		// the noise of every child is collected first, so that it isn't applied to its siblings
+		children := map[string]map[string][]string{}
 		for k, v := range noise {
 			key := k
 			seperatorIndx := strings.IndexByte(k, '.')
@@ -72,21 +157,23 @@ func removeNoisy(element interface{}, noise map[string][]string) interface{} {
 				key = k[:seperatorIndx]
 			}
 
-			val, ok := el[key]
-			if ok {
+			if _, ok := el[key]; ok {
 				// reached the noisy field and it should be deleted.
 				if len(v) == 0 {
 					delete(el, k)
-					delete(noise, k)
+					delete(children, k)
 					continue
 				}
-				// update key of noisy to match heirarchy of noisy field.
-				strArr := noise[k][1:]
-				delete(noise, k)
-				if seperatorIndx != -1 {
-					noise[k[seperatorIndx+1:]] = strArr
+				if children[key] == nil {
+					children[key] = map[string][]string{}
 				}
-				el[key] = removeNoisy(val, noise)
+				// update key of noisy to match heirarchy of noisy field.
+				children[key][k[seperatorIndx+1:]] = v[1:]
+			}
+		}
+		for key, n := range children {
+			if val, ok := el[key]; ok {
+				el[key] = removeNoisy(val, n)
 			}
 		}
 		return el
@@ -94,9 +181,15 @@ func removeNoisy(element interface{}, noise map[string][]string) interface{} {
 	case reflect.Slice:
 		x := reflect.ValueOf(element)
 		var res []interface{}
-		// remove noisy fields from every array element.
+		// remove noisy fields from every array element, the fields keyed by an index
+		// (eg: items.0.name) are only removed from that element.
 		for i := 0; i < x.Len(); i++ {
-			tmp := mapClone(noise)
+			tmp := elementNoise(noise, i)
+			if _, ok := tmp[""]; ok {
+				// the whole element is noisy
+				res = append(res, nil)
+				continue
+			}
 			res = append(res, removeNoisy(x.Index(i).Interface(), tmp))
 		}
 		return res
@@ -105,6 +198,33 @@ func removeNoisy(element interface{}, noise map[string][]string) interface{} {
 	}
 }
 
+// elementNoise returns the noise of the i-th element of an array. The noisy fields keyed by
+// the index of the element are stripped of it, the ones keyed by another index are dropped.
+// An empty key means that the whole element is noisy.
+func elementNoise(noise map[string][]string, i int) map[string][]string {
+	res := map[string][]string{}
+	for k, v := range noise {
+		head, rest := k, ""
+		if indx := strings.IndexByte(k, '.'); indx != -1 {
+			head, rest = k[:indx], k[indx+1:]
+		}
+		n, err := strconv.Atoi(head)
+		if err != nil {
+			res[k] = v
+			continue
+		}
+		if n != i {
+			continue
+		}
+		if len(v) == 0 {
+			res[""] = nil
+			continue
+		}
+		res[rest] = v[1:]
+	}
+	return res
+}
+
 // convertToMap converts array of string into map with key as str(string element of given array)
 // and value as array of string formed by seperating str into substrings (using "." as seperator).
 func convertToMap(arr []string) map[string][]string {
@@ -120,6 +240,11 @@ func convertToMap(arr []string) map[string][]string {
 
 // jsonMatch returns true if expected and actual JSON objects matches(are equal).
 func jsonMatch(expected, actual interface{}) (bool, error) {
+	return jsonCompare(expected, actual, MatchOptions{})
+}
+
+// jsonCompare returns true if expected and actual JSON objects matches, relaxed by opts.
+func jsonCompare(expected, actual interface{}, opts MatchOptions) (bool, error) {
 
 	if reflect.TypeOf(expected) != reflect.TypeOf(actual) {
 		return false, errors.New("type not matched ")
@@ -130,7 +255,7 @@ func jsonMatch(expected, actual interface{}) (bool, error) {
 	x := reflect.ValueOf(expected)
 	switch x.Kind() {
 	case reflect.Float64:
-		if expected != actual {
+		if !floatMatch(expected.(float64), actual.(float64), opts) {
 			return false, nil
 		}
 
@@ -150,14 +275,14 @@ func jsonMatch(expected, actual interface{}) (bool, error) {
 			if !ok {
 				return false, nil
 			}
-			if x, er := jsonMatch(v, val); !x || er != nil {
+			if x, er := jsonCompare(v, val, opts); !x || er != nil {
 				return false, nil
 			}
 		}
 		// checks if there is a key which is not present in expMap but present in actMap.
 		for k := range actMap {
 			_, ok := expMap[k]
-			if !ok {
+			if !ok && !opts.Partial {
 				return false, nil
 			}
 		}
@@ -168,12 +293,24 @@ func jsonMatch(expected, actual interface{}) (bool, error) {
 		if expSlice.Len() != actSlice.Len() {
 			return false, nil
 		}
+		if opts.UnorderedArrays {
+			// every actual element can only be matched by a single expected element
+			matches := make([][]int, expSlice.Len())
+			for i := range matches {
+				for j := 0; j < actSlice.Len(); j++ {
+					if x, err := jsonCompare(expSlice.Index(i).Interface(), actSlice.Index(j).Interface(), opts); err == nil && x {
+						matches[i] = append(matches[i], j)
+					}
+				}
+			}
+			return perfectMatch(matches, actSlice.Len()), nil
+		}
 		isMatched := true
 		for i := 0; i < expSlice.Len(); i++ {
 
 			isMatchedElement := false
 			for j := 0; j < actSlice.Len(); j++ {
-				if x, err := jsonMatch(expSlice.Index(i).Interface(), actSlice.Index(j).Interface()); err == nil && x {
+				if x, err := jsonCompare(expSlice.Index(i).Interface(), actSlice.Index(j).Interface(), opts); err == nil && x {
 					isMatchedElement = true
 					break
 				}
@@ -191,3 +328,48 @@ func jsonMatch(expected, actual interface{}) (bool, error) {
 	return true, nil
 
 }
+
+// perfectMatch reports whether every expected element can be paired with a distinct actual element,
+// matches[i] listing the actual elements matching the expected element i. With a partial match or
+// the float tolerances an element can match several others, so the first fit isn't enough: the
+// pairs are searched with augmenting paths.
+func perfectMatch(matches [][]int, n int) bool {
+	// owner[j] is the expected element paired with the actual element j, -1 when it is free
+	owner := make([]int, n)
+	for j := range owner {
+		owner[j] = -1
+	}
+	var augment func(i int, seen []bool) bool
+	augment = func(i int, seen []bool) bool {
+		for _, j := range matches[i] {
+			if seen[j] {
+				continue
+			}
+			seen[j] = true
+			if owner[j] == -1 || augment(owner[j], seen) {
+				owner[j] = i
+				return true
+			}
+		}
+		return false
+	}
+	for i := range matches {
+		if !augment(i, make([]bool, n)) {
+			return false
+		}
+	}
+	return true
+}
+
+// floatMatch reports whether the numbers are equal, or within the tolerance of opts.
+// The relative tolerance is scaled by the larger magnitude of the two.
+func floatMatch(exp, act float64, opts MatchOptions) bool {
+	if exp == act {
+		return true
+	}
+	diff := math.Abs(exp - act)
+	if diff <= opts.AbsTolerance {
+		return true
+	}
+	return diff <= opts.RelTolerance*math.Max(math.Abs(exp), math.Abs(act))
+}
@@ -2,7 +2,9 @@ package pkg
 
 import (
 	// "encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/go-test/deep"
@@ -1181,6 +1183,25 @@ func TestJsonDiff(t *testing.T) {
 			noise:  []string{"body.url"},
 			result: true,
 		},
+		// the noisy fields keyed by an index are only removed from that element
+		{
+			exp:    `{"items": [{"id": 1, "ts": 10}, {"id": 2, "ts": 20}]}`,
+			actual: `{"items": [{"id": 1, "ts": 11}, {"id": 2, "ts": 20}]}`,
+			noise:  []string{"items.0.ts"},
+			result: true,
+		},
+		{
+			exp:    `{"items": [{"id": 1, "ts": 10}, {"id": 2, "ts": 20}]}`,
+			actual: `{"items": [{"id": 1, "ts": 11}, {"id": 2, "ts": 20}]}`,
+			noise:  []string{"items.1.ts"},
+			result: false,
+		},
+		{
+			exp:    `{"items": [{"id": 1}, {"id": 2}], "groups": [[1, 2], [3]]}`,
+			actual: `{"items": [{"name": "Xlr8"}, {"id": 2}], "groups": [[1, 5], [3]]}`,
+			noise:  []string{"items.0", "groups.0.1"},
+			result: true,
+		},
 	} {
 		logger, _ := zap.NewProduction()
 		defer logger.Sync()
@@ -1196,3 +1217,145 @@ func TestJsonDiff(t *testing.T) {
 	}
 
 }
+
+func TestMatchUnordered(t *testing.T) {
+	for _, tt := range []struct {
+		exp    string
+		actual string
+		noise  []string
+		result bool
+	}{
+		// scalars
+		{exp: `{"tags": ["a", "b", "c"]}`, actual: `{"tags": ["c", "a", "b"]}`, result: true},
+		{exp: `{"tags": ["a", "b", "c"]}`, actual: `{"tags": ["c", "a", "d"]}`, result: false},
+		{exp: `{"tags": ["a", "a", "b"]}`, actual: `{"tags": ["a", "b", "b"]}`, result: false},
+		{exp: `{"tags": ["a", "b"]}`, actual: `{"tags": ["a", "b", "b"]}`, result: false},
+		{exp: `[1, 2.5, true]`, actual: `[true, 1, 2.5]`, result: true},
+		// objects, and arrays nested in them
+		{
+			exp:    `[{"name": "Xlr8", "powers": ["speed", "reflexes"]}, {"name": "Alien-X", "powers": []}]`,
+			actual: `[{"powers": [], "name": "Alien-X"}, {"name": "Xlr8", "powers": ["reflexes", "speed"]}]`,
+			result: true,
+		},
+		{
+			exp:    `[{"name": "Xlr8", "powers": ["speed", "reflexes"]}, {"name": "Alien-X", "powers": []}]`,
+			actual: `[{"powers": [], "name": "Alien-X"}, {"name": "Xlr8", "powers": ["reflexes", "reflexes"]}]`,
+			result: false,
+		},
+		{
+			exp:    `{"aliens": [{"id": 1, "ts": 10}, {"id": 2, "ts": 20}]}`,
+			actual: `{"aliens": [{"id": 2, "ts": 21}, {"id": 1, "ts": 11}]}`,
+			noise:  []string{"aliens.ts"},
+			result: true,
+		},
+	} {
+		logger, _ := zap.NewProduction()
+		res, err := MatchUnordered(tt.exp, tt.actual, tt.noise, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != tt.result {
+			t.Fatal(tt.exp, tt.actual, "THIS IS EXP", tt.result, " \n THIS IS ACT", res)
+		}
+	}
+}
+
+func TestMatchTolerance(t *testing.T) {
+	for _, tt := range []struct {
+		exp    string
+		actual string
+		opts   MatchOptions
+		result bool
+	}{
+		// numbers must be equal without a tolerance
+		{exp: `{"score": 0.1}`, actual: `{"score": 0.1000001}`, result: false},
+		{exp: `{"score": 0.1}`, actual: `{"score": 0.1}`, result: true},
+		// absolute tolerance, at and beyond the boundary
+		{exp: `{"score": 1}`, actual: `{"score": 1.5}`, opts: MatchOptions{AbsTolerance: 0.5}, result: true},
+		{exp: `{"score": 1}`, actual: `{"score": 0.5}`, opts: MatchOptions{AbsTolerance: 0.5}, result: true},
+		{exp: `{"score": 1}`, actual: `{"score": 1.5001}`, opts: MatchOptions{AbsTolerance: 0.5}, result: false},
+		{exp: `{"score": 0.1}`, actual: `{"score": 0.1000001}`, opts: MatchOptions{AbsTolerance: 1e-6}, result: true},
+		{exp: `{"score": 0.1}`, actual: `{"score": 0.100002}`, opts: MatchOptions{AbsTolerance: 1e-6}, result: false},
+		// relative tolerance is scaled by the larger number
+		{exp: `{"power": 1000}`, actual: `{"power": 1010}`, opts: MatchOptions{RelTolerance: 0.01}, result: true},
+		{exp: `{"power": 1000}`, actual: `{"power": 1011}`, opts: MatchOptions{RelTolerance: 0.01}, result: false},
+		{exp: `{"power": -1000}`, actual: `{"power": -1010}`, opts: MatchOptions{RelTolerance: 0.01}, result: true},
+		{exp: `{"power": 0}`, actual: `{"power": 0.001}`, opts: MatchOptions{RelTolerance: 0.01}, result: false},
+		// either tolerance is enough
+		{exp: `{"power": 0}`, actual: `{"power": 0.001}`, opts: MatchOptions{AbsTolerance: 0.001, RelTolerance: 0.01}, result: true},
+		// nested numbers, the other types are still compared exactly
+		{exp: `{"aliens": [{"power": 1.0}, {"power": 2.0}]}`, actual: `{"aliens": [{"power": 1.01}, {"power": 1.99}]}`, opts: MatchOptions{AbsTolerance: 0.02}, result: true},
+		{exp: `{"power": "1"}`, actual: `{"power": "1.01"}`, opts: MatchOptions{AbsTolerance: 0.02}, result: false},
+	} {
+		logger, _ := zap.NewProduction()
+		res, err := MatchWithOptions(tt.exp, tt.actual, nil, tt.opts, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != tt.result {
+			t.Fatal(tt.exp, tt.actual, tt.opts, "THIS IS EXP", tt.result, " \n THIS IS ACT", res)
+		}
+	}
+}
+
+func TestMatchPartial(t *testing.T) {
+	for _, tt := range []struct {
+		exp    string
+		actual string
+		noise  []string
+		result bool
+	}{
+		{exp: `{"name": "Xlr8"}`, actual: `{"name": "Xlr8", "power": 9000, "id": "1"}`, result: true},
+		{exp: `{"name": "Xlr8"}`, actual: `{"name": "Alien-X", "power": 9000}`, result: false},
+		// the expected keys must be present
+		{exp: `{"name": "Xlr8", "power": 9000}`, actual: `{"name": "Xlr8"}`, result: false},
+		// nested objects and the objects in arrays are matched partially too
+		{exp: `{"meta": {"v": 1}}`, actual: `{"meta": {"v": 1, "ts": 2}, "id": 3}`, result: true},
+		{exp: `{"meta": {"v": 1}}`, actual: `{"meta": {"v": 2, "ts": 2}}`, result: false},
+		{exp: `[{"name": "Xlr8"}, {"name": "Alien-X"}]`, actual: `[{"name": "Xlr8", "id": 1}, {"name": "Alien-X", "id": 2}]`, result: true},
+		// but the arrays must have the same number of elements
+		{exp: `[{"name": "Xlr8"}]`, actual: `[{"name": "Xlr8"}, {"name": "Alien-X"}]`, result: false},
+		{exp: `{"name": "Xlr8", "ts": 1}`, actual: `{"name": "Xlr8", "ts": 2, "id": 1}`, noise: []string{"ts"}, result: true},
+	} {
+		logger, _ := zap.NewProduction()
+		res, err := MatchWithOptions(tt.exp, tt.actual, tt.noise, MatchOptions{Partial: true}, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != tt.result {
+			t.Fatal(tt.exp, tt.actual, "THIS IS EXP", tt.result, " \n THIS IS ACT", res)
+		}
+	}
+}
+
+// nested returns a JSON document with depth nested objects around a scalar.
+func nested(depth int) string {
+	return strings.Repeat(`{"a":`, depth) + "1" + strings.Repeat("}", depth)
+}
+
+func TestMatchMaxDepth(t *testing.T) {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	for _, tt := range []struct {
+		depth int
+		max   int
+		err   error
+	}{
+		{depth: 100, max: 0},
+		{depth: 101, max: 0, err: ErrMaxDepth},
+		{depth: 10, max: 10},
+		{depth: 11, max: 10, err: ErrMaxDepth},
+		// far deeper than the stack would allow by recursion
+		{depth: 5000, max: 0, err: ErrMaxDepth},
+	} {
+		doc := nested(tt.depth)
+		ok, err := MatchWithOptions(doc, doc, nil, MatchOptions{MaxDepth: tt.max}, logger)
+		if !errors.Is(err, tt.err) || (tt.err == nil && !ok) {
+			t.Fatal("THIS IS EXP", tt.err, " \n THIS IS ACT", err, tt.depth)
+		}
+	}
+	// the actual document is checked too
+	if _, err := Match(nested(1), nested(200), nil, logger); !errors.Is(err, ErrMaxDepth) {
+		t.Fatal("THIS IS EXP", ErrMaxDepth, " \n THIS IS ACT", err)
+	}
+}
@@ -0,0 +1,32 @@
+package models
+
+import "context"
+
+// DedupCache is the snapshot of the deduplication statistics of the testcases of an index,
+// ie: of a method and uri of an app. It is persisted so that the statistics aren't rebuilt
+// from all the testcases after a restart.
+type DedupCache struct {
+	Index       string                `json:"index" bson:"_id"`
+	CID         string                `json:"cid" bson:"cid"`
+	AppID       string                `json:"app_id" bson:"app_id"`
+	Anchors     []map[string][]string `json:"anchors" bson:"anchors"`
+	NoisyFields map[string]bool       `json:"noisy_fields" bson:"noisy_fields"`
+	// FieldCounts is a list since the values of a field aren't valid document keys, eg: $1.5
+	FieldCounts []FieldCount `json:"field_counts" bson:"field_counts"`
+}
+
+// FieldCount is the number of times a value of a field was seen.
+type FieldCount struct {
+	Key   string `json:"key" bson:"key"`
+	Value string `json:"value" bson:"value"`
+	Count int    `json:"count" bson:"count"`
+}
+
+type DedupCacheDB interface {
+	// Get returns the snapshot of the index, nil if there is none.
+	Get(ctx context.Context, index string) (*DedupCache, error)
+	Put(ctx context.Context, c DedupCache) error
+	Delete(ctx context.Context, index string) error
+	// DeleteApp deletes the snapshots of all the indexes of the app.
+	DeleteApp(ctx context.Context, cid, app string) error
+}
@@ -16,6 +16,10 @@ type HttpResp struct {
 	StatusCode int         `json:"status_code" bson:"status_code,omitempty"` // e.g. 200
 	Header     http.Header `json:"header" bson:"header,omitempty"`
 	Body       string      `json:"body" bson:"body,omitempty"`
+	// Latency is the measured duration of the request in milliseconds, it is only sent by the
+	// clients when testing, eg: by a replay. The server never sees the requests of the tests, so
+	// the clients have to report it for MaxLatency to be asserted.
+	Latency int64 `json:"latency,omitempty" bson:"latency,omitempty"`
 }
 
 type Method string
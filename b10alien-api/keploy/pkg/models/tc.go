@@ -1,6 +1,13 @@
 package models
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by the DBs when no document matches, eg: by TestCaseDB.Get for a missing
+// testcase, so that the services don't depend on the errors of the driver.
+var ErrNotFound = errors.New("document not found")
 
 type TestCase struct {
 	ID       string              `json:"id" bson:"_id"`
@@ -16,16 +23,30 @@ type TestCase struct {
 	AllKeys  map[string][]string `json:"all_keys" bson:"all_keys,omitempty"`
 	Anchors  map[string][]string `json:"anchors" bson:"anchors,omitempty"`
 	Noise    []string            `json:"noise" bson:"noise,omitempty"`
+	// UnorderedArrays compares the JSON arrays of the response as multisets
+	UnorderedArrays bool `json:"unordered_arrays" bson:"unordered_arrays,omitempty"`
+	// PartialMatch only asserts the fields of the expected JSON body, the extra fields of the
+	// actual body are ignored
+	PartialMatch bool `json:"partial_match" bson:"partial_match,omitempty"`
+	// Tags organize the testcases, eg: smoke, auth, so that a subset of the suite is run
+	Tags []string `json:"tags" bson:"tags,omitempty"`
+	// StatusClass only asserts the class of the status code, eg: a 201 passes for an expected 200
+	StatusClass bool `json:"status_class" bson:"status_class,omitempty"`
+	// MaxLatency fails the tests whose measured latency exceeds it, in milliseconds. It is only
+	// asserted when set, and then the tests have to report their latency, see HttpResp.Latency.
+	MaxLatency int64 `json:"max_latency" bson:"max_latency,omitempty"`
 }
 
 type TestCaseDB interface {
 	Upsert(context.Context, TestCase) error
 	UpdateTC(context.Context, TestCase) error
+	// Get returns ErrNotFound when the company has no testcase with the id.
 	Get(ctx context.Context, cid, id string) (TestCase, error)
 	Delete(ctx context.Context, id string) error
-	GetAll(ctx context.Context, cid, app string, anchors bool, offset int, limit int) ([]TestCase, error)
-	GetKeys(ctx context.Context, cid, app, uri string) ([]TestCase, error)
+	// GetAll returns the testcases of the app, only the ones having all the tags if any are given.
+	GetAll(ctx context.Context, cid, app string, tags []string, anchors bool, offset int, limit int) ([]TestCase, error)
+	GetKeys(ctx context.Context, cid, app, uri string, method Method) ([]TestCase, error)
 	//Exists(context.Context, TestCase) (bool, error)
-	DeleteByAnchor(ctx context.Context, cid, app, uri string, filterKeys map[string][]string) error
+	DeleteByAnchor(ctx context.Context, cid, app, uri string, method Method, filterKeys map[string][]string) error
 	GetApps(ctx context.Context, cid string) ([]string, error)
 }
@@ -0,0 +1,54 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/keploy/go-sdk/integrations/kmongo"
+	"go.keploy.io/server/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+func NewDedupCache(c *kmongo.Collection, log *zap.Logger) *dedupCacheDB {
+	return &dedupCacheDB{
+		c:   c,
+		log: log,
+	}
+}
+
+type dedupCacheDB struct {
+	c   *kmongo.Collection
+	log *zap.Logger
+}
+
+func (d *dedupCacheDB) Get(ctx context.Context, index string) (*models.DedupCache, error) {
+	c, err := FindOne[models.DedupCache](ctx, d.c, bson.M{"_id": index})
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (d *dedupCacheDB) Put(ctx context.Context, c models.DedupCache) error {
+	upsert := true
+	opt := &options.UpdateOptions{
+		Upsert: &upsert,
+	}
+	_, err := d.c.UpdateOne(ctx, bson.M{"_id": c.Index}, bson.D{{"$set", c}}, opt)
+	return err
+}
+
+func (d *dedupCacheDB) Delete(ctx context.Context, index string) error {
+	_, err := d.c.DeleteOne(ctx, bson.M{"_id": index})
+	return err
+}
+
+func (d *dedupCacheDB) DeleteApp(ctx context.Context, cid, app string) error {
+	_, err := d.c.DeleteMany(ctx, bson.M{"cid": cid, "app_id": app})
+	return err
+}
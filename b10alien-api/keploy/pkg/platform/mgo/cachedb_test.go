@@ -0,0 +1,52 @@
+package mgo
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/keploy/go-sdk/integrations/kmongo"
+	"go.keploy.io/server/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestDedupCache(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("get", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.dedup-cache", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "cid-app-GET-/b10aliens"}, {Key: "field_counts", Value: bson.A{
+				bson.D{{Key: "key", Value: "body.name"}, {Key: "value", Value: "Xlr8"}, {Key: "count", Value: 2}},
+			}}}))
+		c, err := NewDedupCache(kmongo.NewCollection(mt.Coll), nil).Get(context.Background(), "cid-app-GET-/b10aliens")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		exp := []models.FieldCount{{Key: "body.name", Value: "Xlr8", Count: 2}}
+		if c == nil || !reflect.DeepEqual(c.FieldCounts, exp) {
+			mt.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", c)
+		}
+	})
+
+	mt.Run("missing", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.dedup-cache", mtest.FirstBatch))
+		c, err := NewDedupCache(kmongo.NewCollection(mt.Coll), nil).Get(context.Background(), "cid-app-GET-/b10aliens")
+		if err != nil || c != nil {
+			mt.Fatal("THIS IS EXP", nil, " \n THIS IS ACT", c, err)
+		}
+	})
+
+	mt.Run("delete app", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		err := NewDedupCache(kmongo.NewCollection(mt.Coll), nil).DeleteApp(context.Background(), "cid", "app")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		q := mt.GetStartedEvent().Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q").Document()
+		if q.Lookup("cid").StringValue() != "cid" || q.Lookup("app_id").StringValue() != "app" {
+			mt.Fatal("THIS IS EXP", bson.M{"cid": "cid", "app_id": "app"}, " \n THIS IS ACT", q)
+		}
+	})
+}
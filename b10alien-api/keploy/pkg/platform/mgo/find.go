@@ -0,0 +1,24 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/keploy/go-sdk/integrations/kmongo"
+	"go.keploy.io/server/pkg/models"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrNotFound is returned when no document matches the filter, it is models.ErrNotFound so that
+// the services check it without importing the DB package.
+var ErrNotFound = models.ErrNotFound
+
+// FindOne decodes the first document of the collection matching the filter.
+func FindOne[T any](ctx context.Context, c *kmongo.Collection, filter interface{}) (T, error) {
+	var res T
+	err := c.FindOne(ctx, filter).Decode(&res)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return res, ErrNotFound
+	}
+	return res, err
+}
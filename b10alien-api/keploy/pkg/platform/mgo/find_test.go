@@ -0,0 +1,58 @@
+package mgo
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/keploy/go-sdk/integrations/kmongo"
+	"go.keploy.io/server/pkg/service/run"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestFindOne(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("found", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.tests", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "t-1"}, {Key: "run_id", Value: "run-1"}, {Key: "status", Value: "PASSED"}}))
+		act, err := FindOne[run.Test](context.Background(), kmongo.NewCollection(mt.Coll), bson.M{"_id": "t-1"})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		exp := run.Test{ID: "t-1", RunID: "run-1", Status: run.TestStatusPassed}
+		if !reflect.DeepEqual(act, exp) {
+			mt.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", act)
+		}
+	})
+
+	mt.Run("not found", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.tests", mtest.FirstBatch))
+		_, err := FindOne[run.Test](context.Background(), kmongo.NewCollection(mt.Coll), bson.M{"_id": "t-2"})
+		if err != ErrNotFound {
+			mt.Fatal("THIS IS EXP", ErrNotFound, " \n THIS IS ACT", err)
+		}
+	})
+}
+
+func TestEnsureIndexes(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("create", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		c := kmongo.NewCollection(mt.Coll)
+		if err := NewRun(c, c, nil).EnsureIndexes(context.Background()); err != nil {
+			mt.Fatal(err)
+		}
+		// one createIndexes command for the runs and one for the tests
+		for i := 0; i < 2; i++ {
+			e := mt.GetStartedEvent()
+			if e == nil || e.CommandName != "createIndexes" {
+				mt.Fatal("THIS IS EXP", "createIndexes", " \n THIS IS ACT", e)
+			}
+		}
+	})
+}
@@ -2,16 +2,53 @@ package mgo
 
 import (
 	"context"
+	"time"
+
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"time"
+	"go.uber.org/zap"
 )
 
-func New(uri string) (*mongo.Client, error) {
+// initialBackoff is the wait before the second connection attempt, it doubles on every attempt.
+const initialBackoff = 500 * time.Millisecond
 
+// New connects to mongo and pings it. When mongo isn't ready yet, eg: when it is started by the
+// same docker-compose, the connection is retried up to attempts times with a backoff capped at
+// maxBackoff.
+func New(uri string, attempts int, maxBackoff time.Duration, log *zap.Logger) (*mongo.Client, error) {
 	clientOptions := options.Client().ApplyURI(uri)
-	ctx, _ := context.WithTimeout(context.Background(), 65*time.Second)
-	// defer cancel()
-	return mongo.Connect(ctx, clientOptions)
+	backoff := initialBackoff
+	for i := 1; ; i++ {
+		log.Info("connecting to mongo", zap.Int("attempt", i), zap.Int("max attempts", attempts))
+		client, err := connect(clientOptions)
+		if err == nil {
+			return client, nil
+		}
+		if i >= attempts {
+			return nil, err
+		}
+		log.Warn("failed to connect to mongo, retrying", zap.Int("attempt", i), zap.Duration("backoff", backoff), zap.Error(err))
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
 
+// connect makes a single connection attempt, the client is only returned once mongo answers the ping.
+func connect(clientOptions *options.ClientOptions) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 65*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	pingCtx, pingCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer pingCancel()
+	if err = client.Ping(pingCtx, nil); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	return client, nil
 }
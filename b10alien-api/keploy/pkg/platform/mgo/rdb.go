@@ -2,22 +2,32 @@ package mgo
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"go.keploy.io/server/pkg/service/run"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/keploy/go-sdk/integrations/kmongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 )
 
+// ErrTimeout wraps the error of a query which didn't complete within the timeout of the RunDB,
+// so that it is told apart from a failure of the query itself.
+var ErrTimeout = errors.New("mongo query timed out")
+
 func NewRun(c *kmongo.Collection, test *kmongo.Collection, log *zap.Logger) *RunDB {
 	return &RunDB{
 		c:    c,
 		log:  log,
 		test: test,
+
+		Timeout:        5 * time.Second,
+		CleanupTimeout: 5 * time.Minute,
 	}
 }
 
@@ -25,22 +35,48 @@ type RunDB struct {
 	c    *kmongo.Collection
 	test *kmongo.Collection
 	log  *zap.Logger
+
+	// Timeout bounds every query, on top of the deadline of the incoming context
+	Timeout time.Duration
+	// CleanupTimeout bounds each step of DeleteBefore instead, which deletes a large backlog at once
+	CleanupTimeout time.Duration
 }
 
-func (r *RunDB) ReadTest(ctx context.Context, id string) (run.Test, error) {
+// queryErr wraps err in ErrTimeout if the deadline of the query context is exceeded.
+func queryErr(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+	return err
+}
 
-	// too repetitive
-	// TODO write a generic FindOne for all get calls
-	filter := bson.M{"_id": id}
-	var t run.Test
-	err := r.test.FindOne(ctx, filter).Decode(&t)
+// EnsureIndexes creates the indexes of the queries on the test runs and their tests. Creating an
+// index which already exists is a no-op, so it is safe to call on every startup.
+func (r *RunDB) EnsureIndexes(ctx context.Context) error {
+	_, err := r.c.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "cid", Value: 1}, {Key: "created", Value: -1}}},
+		{Keys: bson.D{{Key: "cid", Value: 1}, {Key: "app", Value: 1}, {Key: "created", Value: -1}}},
+		{Keys: bson.D{{Key: "cid", Value: 1}, {Key: "user", Value: 1}, {Key: "created", Value: -1}}},
+	})
 	if err != nil {
-		return t, err
+		return err
 	}
-	return t, nil
+	_, err = r.test.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "run_id", Value: 1}}},
+	})
+	return err
+}
+
+func (r *RunDB) ReadTest(ctx context.Context, id string) (run.Test, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	t, err := FindOne[run.Test](ctx, r.test, bson.M{"_id": id})
+	return t, queryErr(ctx, err)
 }
 
 func (r *RunDB) ReadTests(ctx context.Context, runID string) ([]run.Test, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
 
 	filter := bson.M{"run_id": runID}
 	findOptions := options.Find()
@@ -48,7 +84,7 @@ func (r *RunDB) ReadTests(ctx context.Context, runID string) ([]run.Test, error)
 	var res []run.Test
 	cur, err := r.test.Find(ctx, filter, findOptions)
 	if err != nil {
-		return nil, err
+		return nil, queryErr(ctx, err)
 	}
 
 	// Loop through the cursor
@@ -56,24 +92,26 @@ func (r *RunDB) ReadTests(ctx context.Context, runID string) ([]run.Test, error)
 		var t run.Test
 		err = cur.Decode(&t)
 		if err != nil {
-			return nil, err
+			return nil, queryErr(ctx, err)
 		}
 		res = append(res, t)
 	}
 
 	if err = cur.Err(); err != nil {
-		return nil, err
+		return nil, queryErr(ctx, err)
 
 	}
 
 	err = cur.Close(ctx)
 	if err != nil {
-		return nil, err
+		return nil, queryErr(ctx, err)
 	}
 	return res, nil
 }
 
 func (r *RunDB) PutTest(ctx context.Context, t run.Test) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
 
 	upsert := true
 	opt := &options.UpdateOptions{
@@ -85,34 +123,69 @@ func (r *RunDB) PutTest(ctx context.Context, t run.Test) error {
 	_, err := r.test.UpdateOne(ctx, filter, update, opt)
 	if err != nil {
 		//t.log.Error("failed to insert testcase into DB", zap.String("cid", tc.CID), zap.String("appid", tc.AppID), zap.String("id", tc.ID), zap.Error())
-		return err
+		return queryErr(ctx, err)
 	}
 	return nil
 }
 
-func (r *RunDB) Read(ctx context.Context, cid string, user, app, id *string, from, to *time.Time, offset int, limit int) ([]*run.TestRun, error) {
-
-	filter := bson.M{
-		"cid": cid,
+// Stats counts the tests of the run by status with an aggregation, so that the tests aren't read.
+func (r *RunDB) Stats(ctx context.Context, runID string) (run.TestStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	var stats run.TestStats
+	cur, err := r.test.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"run_id": runID}}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}, "last_started": bson.M{"$max": "$started"}}}},
+	})
+	if err != nil {
+		return stats, queryErr(ctx, err)
 	}
-	if user != nil {
-		filter["user"] = user
+	var counts []struct {
+		Status      run.TestStatus `bson:"_id"`
+		Count       int            `bson:"count"`
+		LastStarted int64          `bson:"last_started"`
 	}
-
-	if app != nil {
-		filter["app"] = app
+	if err = cur.All(ctx, &counts); err != nil {
+		return stats, queryErr(ctx, err)
 	}
-	if id != nil {
-		filter["_id"] = id
+	for _, c := range counts {
+		stats.Total += c.Count
+		if c.LastStarted > stats.LastStarted {
+			stats.LastStarted = c.LastStarted
+		}
+		switch c.Status {
+		case run.TestStatusPassed:
+			stats.Passed = c.Count
+		case run.TestStatusFailed:
+			stats.Failed = c.Count
+		case run.TestStatusRunning:
+			stats.Running = c.Count
+		case run.TestStatusPending:
+			stats.Pending = c.Count
+		}
 	}
+	return stats, nil
+}
 
-	if from != nil {
-		filter["updated"] = bson.M{"$gte": from.Unix()}
+// DeleteTest deletes the test with the given id, ErrNotFound is returned if it doesn't exist.
+func (r *RunDB) DeleteTest(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	res, err := r.test.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return queryErr(ctx, err)
 	}
-
-	if to != nil {
-		filter["updated"] = bson.M{"$lte": to.Unix()}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
 	}
+	return nil
+}
+
+func (r *RunDB) Read(ctx context.Context, cid string, user, app, id *string, from, to *time.Time, offset int, limit int) ([]*run.TestRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	filter := readFilter(cid, user, app, id, from, to)
 
 	var tcs []*run.TestRun
 	opt := options.Find()
@@ -123,7 +196,7 @@ func (r *RunDB) Read(ctx context.Context, cid string, user, app, id *string, fro
 
 	cur, err := r.c.Find(ctx, filter, opt)
 	if err != nil {
-		return nil, err
+		return nil, queryErr(ctx, err)
 	}
 
 	// Loop through the cursor
@@ -131,25 +204,59 @@ func (r *RunDB) Read(ctx context.Context, cid string, user, app, id *string, fro
 		var tc *run.TestRun
 		err = cur.Decode(&tc)
 		if err != nil {
-			return nil, err
+			return nil, queryErr(ctx, err)
 
 		}
 		tcs = append(tcs, tc)
 	}
 
 	if err = cur.Err(); err != nil {
-		return nil, err
+		return nil, queryErr(ctx, err)
 
 	}
 
 	err = cur.Close(ctx)
 	if err != nil {
-		return nil, err
+		return nil, queryErr(ctx, err)
 	}
 	return tcs, nil
 }
 
+// readFilter returns the filter of the test runs matching the given fields,
+// from and to are the inclusive bounds of the update time.
+func readFilter(cid string, user, app, id *string, from, to *time.Time) bson.M {
+	filter := bson.M{
+		"cid": cid,
+	}
+	if user != nil {
+		filter["user"] = user
+	}
+
+	if app != nil {
+		filter["app"] = app
+	}
+	if id != nil {
+		filter["_id"] = id
+	}
+
+	// both bounds go in the same condition, a second assignment would drop the first one
+	updated := bson.M{}
+	if from != nil {
+		updated["$gte"] = from.Unix()
+	}
+
+	if to != nil {
+		updated["$lte"] = to.Unix()
+	}
+	if len(updated) > 0 {
+		filter["updated"] = updated
+	}
+	return filter
+}
+
 func (r *RunDB) Upsert(ctx context.Context, testRun run.TestRun) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
 
 	upsert := true
 	opt := &options.UpdateOptions{
@@ -161,12 +268,63 @@ func (r *RunDB) Upsert(ctx context.Context, testRun run.TestRun) error {
 	_, err := r.c.UpdateOne(ctx, filter, update, opt)
 	if err != nil {
 		//t.log.Error("failed to insert testcase into DB", zap.String("cid", tc.CID), zap.String("appid", tc.AppID), zap.String("id", tc.ID), zap.Error())
-		return err
+		return queryErr(ctx, err)
 	}
 	return nil
 }
 
+// DeleteRun deletes the test run and its tests, the tests go first so that a failure never
+// leaves orphaned tests behind.
+func (r *RunDB) DeleteRun(ctx context.Context, runID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	res, err := r.test.DeleteMany(ctx, bson.M{"run_id": runID})
+	if err != nil {
+		return 0, queryErr(ctx, err)
+	}
+	_, err = r.c.DeleteOne(ctx, bson.M{"_id": runID})
+	if err != nil {
+		return res.DeletedCount, queryErr(ctx, err)
+	}
+	return res.DeletedCount, nil
+}
+
+// DeleteBefore deletes the test runs created before the cutoff with their tests, the tests go
+// first like in DeleteRun so that a failed cleanup is simply retried. Every step gets its own
+// CleanupTimeout, so that a large backlog doesn't time out halfway through.
+func (r *RunDB) DeleteBefore(ctx context.Context, cid string, cutoff time.Time) (int64, int64, error) {
+	filter := bson.M{"cid": cid, "created": bson.M{"$lt": cutoff.Unix()}}
+	stepCtx, cancel := context.WithTimeout(ctx, r.CleanupTimeout)
+	ids, err := r.c.Distinct(stepCtx, "_id", filter)
+	err = queryErr(stepCtx, err)
+	cancel()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+
+	stepCtx, cancel = context.WithTimeout(ctx, r.CleanupTimeout)
+	tests, err := r.test.DeleteMany(stepCtx, bson.M{"run_id": bson.M{"$in": ids}})
+	err = queryErr(stepCtx, err)
+	cancel()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stepCtx, cancel = context.WithTimeout(ctx, r.CleanupTimeout)
+	defer cancel()
+	runs, err := r.c.DeleteMany(stepCtx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, tests.DeletedCount, queryErr(stepCtx, err)
+	}
+	return runs.DeletedCount, tests.DeletedCount, nil
+}
+
 func (r *RunDB) Increment(ctx context.Context, success, failure bool, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
 
 	update := bson.M{}
 	if success {
@@ -182,7 +340,7 @@ func (r *RunDB) Increment(ctx context.Context, success, failure bool, id string)
 	}, update, options.Update().SetUpsert(true))
 
 	if err != nil {
-		return err
+		return queryErr(ctx, err)
 	}
 	return nil
 }
@@ -0,0 +1,176 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/keploy/go-sdk/integrations/kmongo"
+	"go.keploy.io/server/pkg/service/run"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestReadFilter(t *testing.T) {
+	from := time.Unix(1650000000, 0)
+	to := time.Unix(1660000000, 0)
+	app := "b10alien-api"
+	for _, tt := range []struct {
+		from, to *time.Time
+		exp      bson.M
+	}{
+		{
+			from: &from,
+			to:   &to,
+			exp:  bson.M{"cid": "cid", "app": &app, "updated": bson.M{"$gte": from.Unix(), "$lte": to.Unix()}},
+		},
+		{
+			from: &from,
+			exp:  bson.M{"cid": "cid", "app": &app, "updated": bson.M{"$gte": from.Unix()}},
+		},
+		{
+			to:  &to,
+			exp: bson.M{"cid": "cid", "app": &app, "updated": bson.M{"$lte": to.Unix()}},
+		},
+		{
+			exp: bson.M{"cid": "cid", "app": &app},
+		},
+	} {
+		act := readFilter("cid", nil, &app, nil, tt.from, tt.to)
+		if !reflect.DeepEqual(act, tt.exp) {
+			t.Fatal("THIS IS EXP", tt.exp, " \n THIS IS ACT", act)
+		}
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("timeout", func(mt *mtest.T) {
+		c := kmongo.NewCollection(mt.Coll)
+		rdb := NewRun(c, c, nil)
+		rdb.Timeout = time.Nanosecond
+		_, err := rdb.ReadTests(context.Background(), "run-1")
+		if !errors.Is(err, ErrTimeout) {
+			mt.Fatal("THIS IS EXP", ErrTimeout, " \n THIS IS ACT", err)
+		}
+	})
+
+	mt.Run("failure", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "bad query"}))
+		c := kmongo.NewCollection(mt.Coll)
+		err := NewRun(c, c, nil).Upsert(context.Background(), run.TestRun{ID: "run-1"})
+		var cmdErr mongo.CommandError
+		if errors.Is(err, ErrTimeout) || !errors.As(err, &cmdErr) {
+			mt.Fatal("expected the error of the query, not a timeout", err)
+		}
+	})
+}
+
+func TestDeleteTest(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	for _, tt := range []struct {
+		name    string
+		deleted int
+		err     error
+	}{
+		{name: "existing", deleted: 1},
+		{name: "missing", deleted: 0, err: ErrNotFound},
+	} {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: tt.deleted}})
+			c := kmongo.NewCollection(mt.Coll)
+			if err := NewRun(c, c, nil).DeleteTest(context.Background(), "t-1"); err != tt.err {
+				mt.Fatal("THIS IS EXP", tt.err, " \n THIS IS ACT", err)
+			}
+		})
+	}
+}
+
+func TestDeleteBefore(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("old runs", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "values", Value: bson.A{"run-1", "run-2"}}},
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 5}},
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 2}})
+		c := kmongo.NewCollection(mt.Coll)
+		runs, tests, err := NewRun(c, kmongo.NewCollection(mt.DB.Collection("tests")), nil).DeleteBefore(context.Background(), "cid", time.Unix(200, 0))
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if runs != 2 || tests != 5 {
+			mt.Fatal("THIS IS EXP", 2, 5, " \n THIS IS ACT", runs, tests)
+		}
+		// only the runs created before the cutoff are selected
+		e := mt.GetStartedEvent()
+		if lt := e.Command.Lookup("query", "created", "$lt").Int64(); e.CommandName != "distinct" || lt != 200 {
+			mt.Fatal("THIS IS EXP", 200, " \n THIS IS ACT", e.Command)
+		}
+		// the tests are deleted before their runs
+		if e := mt.GetStartedEvent(); e.CommandName != "delete" || e.Command.Lookup("delete").StringValue() != "tests" {
+			mt.Fatal("THIS IS EXP", "delete", " \n THIS IS ACT", e.Command)
+		}
+	})
+
+	mt.Run("cleanup timeout", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "values", Value: bson.A{"run-1"}}},
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}},
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}})
+		c := kmongo.NewCollection(mt.Coll)
+		rdb := NewRun(c, c, nil)
+		// the cleanup isn't bound by the timeout of the other queries
+		rdb.Timeout = time.Nanosecond
+		if _, _, err := rdb.DeleteBefore(context.Background(), "cid", time.Unix(200, 0)); err != nil {
+			mt.Fatal(err)
+		}
+		rdb.CleanupTimeout = time.Nanosecond
+		if _, _, err := rdb.DeleteBefore(context.Background(), "cid", time.Unix(200, 0)); !errors.Is(err, ErrTimeout) {
+			mt.Fatal("THIS IS EXP", ErrTimeout, " \n THIS IS ACT", err)
+		}
+	})
+
+	mt.Run("nothing to delete", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "values", Value: bson.A{}}})
+		c := kmongo.NewCollection(mt.Coll)
+		runs, tests, err := NewRun(c, c, nil).DeleteBefore(context.Background(), "cid", time.Unix(200, 0))
+		if err != nil || runs != 0 || tests != 0 {
+			mt.Fatal("THIS IS EXP", 0, 0, " \n THIS IS ACT", runs, tests, err)
+		}
+	})
+}
+
+func TestStats(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("stats", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.tests", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "PASSED"}, {Key: "count", Value: 3}, {Key: "last_started", Value: int64(30)}},
+			bson.D{{Key: "_id", Value: "FAILED"}, {Key: "count", Value: 2}, {Key: "last_started", Value: int64(50)}},
+			bson.D{{Key: "_id", Value: "RUNNING"}, {Key: "count", Value: 1}, {Key: "last_started", Value: int64(40)}}))
+		c := kmongo.NewCollection(mt.Coll)
+		act, err := NewRun(c, c, nil).Stats(context.Background(), "run-1")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		exp := run.TestStats{Passed: 3, Failed: 2, Running: 1, Total: 6, LastStarted: 50}
+		if act != exp {
+			mt.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", act)
+		}
+		// the counts are grouped by status in the database
+		e := mt.GetStartedEvent()
+		if e == nil || e.CommandName != "aggregate" {
+			mt.Fatal("THIS IS EXP", "aggregate", " \n THIS IS ACT", e)
+		}
+	})
+}
@@ -54,10 +54,13 @@ func (t *testCaseDB) GetApps(ctx context.Context, cid string) ([]string, error)
 	return apps, nil
 }
 
-func (t *testCaseDB) GetKeys(ctx context.Context, cid, app, uri string) ([]models.TestCase, error) {
+func (t *testCaseDB) GetKeys(ctx context.Context, cid, app, uri string, method models.Method) ([]models.TestCase, error) {
 	filter := bson.M{"cid": cid, "app_id": app, "uri": uri}
+	if method != "" {
+		filter["http_req.method"] = method
+	}
 	findOptions := options.Find()
-	findOptions.SetProjection(bson.M{"anchors": 1, "all_keys": 1})
+	findOptions.SetProjection(bson.M{"anchors": 1, "all_keys": 1, "noise": 1})
 	return t.getAll(ctx, filter, findOptions)
 }
 
@@ -89,13 +92,17 @@ func (t *testCaseDB) GetKeys(ctx context.Context, cid, app, uri string) ([]model
 //	return false, nil
 //}
 
-func (t *testCaseDB) DeleteByAnchor(ctx context.Context, cid, app, uri string, filterKeys map[string][]string) error {
+func (t *testCaseDB) DeleteByAnchor(ctx context.Context, cid, app, uri string, method models.Method, filterKeys map[string][]string) error {
 
 	filters := bson.M{
 		"cid":    cid,
 		"app_id": app,
 		"uri":    uri,
 	}
+	// the testcases captured without a method are matched by the uri alone
+	if method != "" {
+		filters["http_req.method"] = method
+	}
 	_, err := t.c.UpdateMany(ctx, filters, bson.M{
 		"$set": bson.M{"anchors": filterKeys},
 	})
@@ -195,19 +202,11 @@ func (t *testCaseDB) Upsert(ctx context.Context, tc models.TestCase) error {
 }
 
 func (t *testCaseDB) Get(ctx context.Context, cid, id string) (models.TestCase, error) {
-	// too repetitive
-	// TODO write a generic FindOne for all get calls
 	filter := bson.M{"_id": id}
 	if cid != "" {
 		filter["cid"] = cid
 	}
-
-	var tc models.TestCase
-	err := t.c.FindOne(ctx, filter).Decode(&tc)
-	if err != nil {
-		return tc, err
-	}
-	return tc, nil
+	return FindOne[models.TestCase](ctx, t.c, filter)
 }
 
 func (t *testCaseDB) getAll(ctx context.Context, filter bson.M, findOptions *options.FindOptions) ([]models.TestCase, error) {
@@ -240,9 +239,12 @@ func (t *testCaseDB) getAll(ctx context.Context, filter bson.M, findOptions *opt
 	return tcs, nil
 }
 
-func (t *testCaseDB) GetAll(ctx context.Context, cid, app string, anchors bool, offset int, limit int) ([]models.TestCase, error) {
+func (t *testCaseDB) GetAll(ctx context.Context, cid, app string, tags []string, anchors bool, offset int, limit int) ([]models.TestCase, error) {
 
 	filter := bson.M{"cid": cid, "app_id": app}
+	if len(tags) > 0 {
+		filter["tags"] = bson.M{"$all": tags}
+	}
 	findOptions := options.Find()
 	if !anchors {
 		findOptions.SetProjection(bson.M{"anchors": 0, "all_keys": 0})
@@ -0,0 +1,76 @@
+package mgo
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/keploy/go-sdk/integrations/kmongo"
+	"go.keploy.io/server/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestGet(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("found", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.test-cases", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "1"}, {Key: "cid", Value: "cid"}, {Key: "app_id", Value: "app"}}))
+		tc, err := NewTestCase(kmongo.NewCollection(mt.Coll), nil).Get(context.Background(), "cid", "1")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if exp := (models.TestCase{ID: "1", CID: "cid", AppID: "app"}); !reflect.DeepEqual(tc, exp) {
+			mt.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", tc)
+		}
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		if cid, ok := filter.Lookup("cid").StringValueOK(); !ok || cid != "cid" {
+			mt.Fatal("THIS IS EXP", "cid", " \n THIS IS ACT", filter)
+		}
+	})
+
+	mt.Run("not found", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.test-cases", mtest.FirstBatch))
+		_, err := NewTestCase(kmongo.NewCollection(mt.Coll), nil).Get(context.Background(), "cid", "2")
+		if err != ErrNotFound {
+			mt.Fatal("THIS IS EXP", ErrNotFound, " \n THIS IS ACT", err)
+		}
+	})
+}
+
+func TestTags(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("filter", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.test-cases", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "1"}, {Key: "tags", Value: bson.A{"smoke", "auth"}}}))
+		tcs, err := NewTestCase(kmongo.NewCollection(mt.Coll), nil).GetAll(context.Background(), "cid", "app", []string{"smoke", "auth"}, false, 0, 25)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if len(tcs) != 1 || !reflect.DeepEqual(tcs[0].Tags, []string{"smoke", "auth"}) {
+			mt.Fatal("THIS IS EXP", []string{"smoke", "auth"}, " \n THIS IS ACT", tcs)
+		}
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		var tags []string
+		if err := filter.Lookup("tags", "$all").Unmarshal(&tags); err != nil || !reflect.DeepEqual(tags, []string{"smoke", "auth"}) {
+			mt.Fatal("THIS IS EXP", []string{"smoke", "auth"}, " \n THIS IS ACT", filter)
+		}
+	})
+
+	mt.Run("update", func(mt *mtest.T) {
+		// the update only sets the request and the response, so the tags are kept
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		err := NewTestCase(kmongo.NewCollection(mt.Coll), nil).UpdateTC(context.Background(), models.TestCase{ID: "1"})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		set := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set").Document()
+		if _, err := set.LookupErr("tags"); err == nil {
+			mt.Fatal("the tags are overwritten by UpdateTC", set)
+		}
+	})
+}
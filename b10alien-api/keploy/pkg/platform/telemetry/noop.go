@@ -0,0 +1,34 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// EnvTelemetry is the environment variable disabling the telemetry when set to "off".
+const EnvTelemetry = "KEPLOY_TELEMETRY"
+
+// Disabled reports whether the telemetry is turned off by the environment.
+func Disabled() bool {
+	return strings.EqualFold(os.Getenv(EnvTelemetry), "off")
+}
+
+// FromEnv returns a no-op telemetry if it is turned off by the environment, otherwise the given one.
+func FromEnv(tele Service) Service {
+	if Disabled() {
+		return Noop{}
+	}
+	return tele
+}
+
+// Noop is a telemetry which never sends any event.
+type Noop struct{}
+
+func (Noop) Ping(bool)                                      {}
+func (Noop) Normalize(http.Client, context.Context)         {}
+func (Noop) EditTc(http.Client, context.Context)            {}
+func (Noop) Testrun(int, int, http.Client, context.Context) {}
+func (Noop) DeleteTc(http.Client, context.Context)          {}
+func (Noop) GetApps(int, http.Client, context.Context)      {}
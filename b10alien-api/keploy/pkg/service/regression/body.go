@@ -0,0 +1,46 @@
+package regression
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// decodeBody returns the body decompressed if it is gzip encoded as per its Content-Encoding
+// header. The body is returned as it is if it isn't encoded or isn't valid gzip.
+func decodeBody(h http.Header, body string) string {
+	if !strings.EqualFold(strings.TrimSpace(h.Get("Content-Encoding")), "gzip") || body == "" {
+		return body
+	}
+	zr, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		return body
+	}
+	defer zr.Close()
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return body
+	}
+	return string(b)
+}
+
+// isForm reports whether the Content-Type header is application/x-www-form-urlencoded.
+func isForm(h http.Header) bool {
+	t, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && t == "application/x-www-form-urlencoded"
+}
+
+// addForm adds the fields of the url encoded form body to m, keyed like the JSON fields, eg: body.name.
+func addForm(body string, m map[string][]string) error {
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return err
+	}
+	for k, v := range form {
+		m["body."+k] = v
+	}
+	return nil
+}
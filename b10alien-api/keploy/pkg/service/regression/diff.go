@@ -0,0 +1,70 @@
+package regression
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.keploy.io/server/pkg/service/run"
+)
+
+// bodyDiffs returns the body fields whose values differ between the flattened expected and actual
+// responses, skipping the noisy ones and, if partial is set, the ones missing in the expected
+// response. The values are compared regardless of their order, since
+// the elements of an array are merged under the same key unless they are indexed.
+func bodyDiffs(exp, act map[string][]string, noise []string, partial bool) []run.BodyDiff {
+	keys := map[string]bool{}
+	for k := range exp {
+		keys[k] = true
+	}
+	for k := range act {
+		keys[k] = true
+	}
+	var diffs []run.BodyDiff
+	for k := range keys {
+		if (k != "body" && !strings.HasPrefix(k, "body.")) || isNoisy(noise, k) {
+			continue
+		}
+		e, a := exp[k], act[k]
+		if e == nil && partial {
+			continue
+		}
+		if e != nil && a != nil && reflect.DeepEqual(sortedCopy(e), sortedCopy(a)) {
+			continue
+		}
+		diffs = append(diffs, run.BodyDiff{Key: k, Expected: displayValues(e), Actual: displayValues(a)})
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Key < diffs[j].Key })
+	return diffs
+}
+
+// isNoisy reports whether the key, or one of the fields it is nested in, is a noise field.
+func isNoisy(noise []string, key string) bool {
+	for _, n := range noise {
+		if key == n || strings.HasPrefix(key, n+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// displayValues returns the flattened values with the nulls written like in JSON.
+func displayValues(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	c := make([]string, len(s))
+	for i, v := range s {
+		if v == nullValue {
+			v = "null"
+		}
+		c[i] = v
+	}
+	return c
+}
+
+func sortedCopy(s []string) []string {
+	c := append([]string{}, s...)
+	sort.Strings(c)
+	return c
+}
@@ -0,0 +1,150 @@
+package regression
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// requestNoisePrefix marks a noise entry as a field of the request, eg: req.body.nonce. The request
+// noise is only used by the deduplication, the responses are compared without it.
+const requestNoisePrefix = "req."
+
+// regexNoisePrefix marks a noise entry as a regular expression over the flattened keys,
+// eg: re:body.items.\d+.id
+const regexNoisePrefix = "re:"
+
+// noiseRegexp returns the compiled pattern of a regex noise entry. The pattern has to match the
+// whole key. The compiled patterns are cached since the same noise is applied on every test run.
+func (r *Regression) noiseRegexp(pattern string) (*regexp.Regexp, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if re, ok := r.noiseRegexps[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid noise regex %q: %v", pattern, err)
+	}
+	r.noiseRegexps[pattern] = re
+	return re, nil
+}
+
+// expandNoise replaces the pattern noise entries by the flattened keys they match, the exact
+// entries are returned as they are.
+//
+// An entry prefixed by "re:" is a regex, otherwise an entry holding a "*" is a wildcard. The
+// patterns only add keys to the noise, so when an exact and a wildcard entry match the same key
+// it is ignored either way and appears once in the result. An exact entry never matches a key
+// partially, eg: "body.items" ignores the whole items field while "body.items.*" only ignores
+// the fields nested one level below it.
+func (r *Regression) expandNoise(noise []string, keys ...map[string][]string) ([]string, error) {
+	var res []string
+	seen := map[string]bool{}
+	add := func(k string) {
+		if !seen[k] {
+			seen[k] = true
+			res = append(res, k)
+		}
+	}
+	for _, n := range noise {
+		var match func(string) bool
+		switch {
+		case strings.HasPrefix(n, regexNoisePrefix):
+			re, err := r.noiseRegexp(strings.TrimPrefix(n, regexNoisePrefix))
+			if err != nil {
+				return nil, err
+			}
+			match = re.MatchString
+		case strings.Contains(n, "*"):
+			// validate the pattern once, so that a bad pattern isn't silently ignored
+			if _, err := path.Match(n, ""); err != nil {
+				return nil, fmt.Errorf("invalid noise wildcard %q: %v", n, err)
+			}
+			match = func(k string) bool { return globMatch(n, k) }
+		default:
+			add(n)
+			continue
+		}
+		for _, m := range keys {
+			for k := range m {
+				if match(k) {
+					add(k)
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// globMatch matches the dotted key against the wildcard pattern segment by segment, with the
+// semantics of path.Match. A "*" hence matches a single segment of the key, never a ".".
+func globMatch(pattern, key string) bool {
+	ps, ks := strings.Split(pattern, "."), strings.Split(key, ".")
+	if len(ps) != len(ks) {
+		return false
+	}
+	for i := range ps {
+		if ok, err := path.Match(ps[i], ks[i]); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny reports whether the key is matched by one of the patterns.
+func matchAny(patterns []*regexp.Regexp, key string) bool {
+	for _, re := range patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeNoise returns the existing noise fields followed by the new ones, without the duplicates
+// and the fields already covered by a regex noise entry.
+func (r *Regression) mergeNoise(noise, fields []string) ([]string, error) {
+	var patterns []*regexp.Regexp
+	for _, n := range noise {
+		if !strings.HasPrefix(n, regexNoisePrefix) {
+			continue
+		}
+		re, err := r.noiseRegexp(strings.TrimPrefix(n, regexNoisePrefix))
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, re)
+	}
+	var res []string
+	seen := map[string]bool{}
+	for _, n := range noise {
+		if !seen[n] {
+			seen[n] = true
+			res = append(res, n)
+		}
+	}
+	for _, n := range fields {
+		if !seen[n] && !matchAny(patterns, n) {
+			seen[n] = true
+			res = append(res, n)
+		}
+	}
+	return res, nil
+}
+
+// responseKeys returns the flattened keys of the headers and the body of a response,
+// in the same form as the noise fields. A url encoded form body is keyed by its fields.
+func (r *Regression) responseKeys(h http.Header, body string) (map[string][]string, error) {
+	m := map[string][]string{}
+	for k, v := range h {
+		m["header."+k] = []string{strings.Join(v, "")}
+	}
+	if isForm(h) && addForm(body, m) == nil {
+		return m, nil
+	}
+	err := r.addBody(body, m)
+	return m, err
+}
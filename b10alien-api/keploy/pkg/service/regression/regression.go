@@ -1,12 +1,16 @@
 package regression
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
 	"net/http"
+	"net/url"
 	"reflect"
 	"regexp"
 	"sort"
@@ -23,10 +27,16 @@ import (
 	"go.uber.org/zap"
 )
 
+// ErrTestCaseNotFound is returned when no testcase has the given id.
+var ErrTestCaseNotFound = errors.New("testcase not found")
+
+// defaultMaxDedupBodySize is larger than the request bodies of most APIs.
+const defaultMaxDedupBodySize = 8 << 20
+
 func New(tdb models.TestCaseDB, rdb run.DB, log *zap.Logger, EnableDeDup bool, adb telemetry.Service, client http.Client) *Regression {
 	return &Regression{
 		tdb:         tdb,
-		tele:        adb,
+		tele:        telemetry.FromEnv(adb),
 		log:         log,
 		rdb:         rdb,
 		client:      client,
@@ -34,19 +44,29 @@ func New(tdb models.TestCaseDB, rdb run.DB, log *zap.Logger, EnableDeDup bool, a
 		anchors:     map[string][]map[string][]string{},
 		noisyFields: map[string]map[string]bool{},
 		fieldCounts: map[string]map[string]map[string]int{},
+		indexLocks:  map[string]*sync.Mutex{},
 		EnableDeDup: EnableDeDup,
+
+		noiseRegexps: map[string]*regexp.Regexp{},
+
+		AnchorMinSamples:     20,
+		AnchorMaxUniqueRatio: 0.40,
+		IgnoredHeaders:       pkg.DefaultIgnoredHeaders,
+		MaxDedupBodySize:     defaultMaxDedupBodySize,
+		MaxJSONDepth:         pkg.DefaultMaxDepth,
 	}
 }
 
 type Regression struct {
-	tdb      models.TestCaseDB
-	tele     telemetry.Service
-	rdb      run.DB
-	client   http.Client
-	log      *zap.Logger
+	tdb    models.TestCaseDB
+	tele   telemetry.Service
+	rdb    run.DB
+	client http.Client
+	log    *zap.Logger
+	// mu guards the cache maps themselves, the entries of an index are guarded by its index lock
 	mu       sync.Mutex
 	appCount int
-	// index is `cid-appID-uri`
+	// index is `cid-appID-method-uri`
 	//
 	// anchors is map[index][]map[key][]value or map[index]combinationOfAnchors
 	// anchors stores all the combinations of anchor fields for a particular index
@@ -62,32 +82,121 @@ type Regression struct {
 	// fieldCounts stores the count of all values of a particular field in an index.
 	// eg: lets say field is bloodGroup then the value would be {A+: 20, B+: 10,...}
 	fieldCounts map[string]map[string]map[string]int
+	// indexLocks is map[index]lock, it serializes the deduplication of the testcases of the same index
+	// so that the different indexes are deduplicated concurrently.
+	indexLocks map[string]*sync.Mutex
+	// noiseRegexps is map[pattern]compiledPattern of the regex noise entries
+	noiseRegexps map[string]*regexp.Regexp
+	// CacheDB persists the cache of every index when set, so that the cache isn't rebuilt from all
+	// the testcases after a restart. The cache is only kept in memory when it is nil.
+	CacheDB     models.DedupCacheDB
 	EnableDeDup bool
+	// SortBodyKeys normalises the expected and actual JSON bodies stored in the test result
+	// by recursively sorting object keys, so that visual diffs only show real value changes.
+	SortBodyKeys bool
+	// AnchorMinSamples is the number of values a field needs before its variance is judged,
+	// the fields with fewer values are anchors.
+	AnchorMinSamples int
+	// AnchorMaxUniqueRatio is the ratio of unique values to total values below which a field is
+	// low variance, and hence an anchor.
+	AnchorMaxUniqueRatio float64
+	// FloatAbsTolerance and FloatRelTolerance are the absolute and relative differences allowed
+	// between the numbers of the expected and actual JSON bodies, eg: an absolute tolerance of 1e-6
+	// matches 0.1000001 with 0.1. Zero means an exact match.
+	FloatAbsTolerance float64
+	FloatRelTolerance float64
+	// CaseInsensitiveHeaders lists the headers whose values are compared ignoring the case.
+	CaseInsensitiveHeaders []string
+	// IgnoredHeaders lists the headers skipped in every testcase, as if they were noisy. It is
+	// pkg.DefaultIgnoredHeaders by default, set it to nil to assert them.
+	IgnoredHeaders []string
+	// UnorderedHeaders lists the headers whose values are compared regardless of their order.
+	UnorderedHeaders []string
+	// EmptyAsMissingHeaders lists the headers for which an empty value and a missing header are equal.
+	EmptyAsMissingHeaders []string
+	// IndexArrays keys the flattened array elements by their index, eg: items.0.name instead of
+	// items.name, so that the noise fields target a single element and the deduplication tells
+	// reordered arrays apart.
+	IndexArrays bool
+	// MaxDedupBodySize is the size in bytes above which the deduplication doesn't flatten a request
+	// body but compares it as a whole, which bounds its cost for the large payloads.
+	MaxDedupBodySize int
+	// MaxJSONDepth is the deepest nesting of the JSON and XML bodies, the deeper ones fail to be
+	// compared and deduplicated instead of exhausting the stack. It is pkg.DefaultMaxDepth by default.
+	MaxJSONDepth int
 }
 
 func (r *Regression) DeleteTC(ctx context.Context, cid, id string) error {
-	// reset cache
-	r.mu.Lock()
-	defer r.mu.Unlock()
 	t, err := r.tdb.Get(ctx, cid, id)
 	if err != nil {
 		r.log.Error("failed to get testcases from the DB", zap.String("cid", cid), zap.Error(err))
 		return errors.New("internal failure")
 	}
-	index := fmt.Sprintf("%s-%s-%s", t.CID, t.AppID, t.URI)
-	delete(r.anchors, index)
 	err = r.tdb.Delete(ctx, id)
 	if err != nil {
 		r.log.Error("failed to delete testcase from the DB", zap.String("cid", cid), zap.String("appID", t.AppID), zap.Error(err))
 		return errors.New("internal failure")
 	}
+	// reset cache
+	r.invalidateIndex(ctx, dedupIndex(&t))
 
 	r.tele.DeleteTc(r.client, ctx)
 	return nil
 }
 
+// DeleteResult is the outcome of the deletion of a testcase by DeleteTCs.
+type DeleteResult struct {
+	ID      string `json:"id"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DeleteTCs deletes the testcases with the given ids and reports the outcome of every deletion, a
+// failed deletion doesn't stop the others. The caches of the affected indexes are reset once.
+func (r *Regression) DeleteTCs(ctx context.Context, cid string, ids []string) ([]DeleteResult, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("no testcase to delete")
+	}
+	var (
+		res     []DeleteResult
+		deleted int
+	)
+	indexes := map[string]bool{}
+	for _, id := range ids {
+		t, err := r.tdb.Get(ctx, cid, id)
+		if errors.Is(err, models.ErrNotFound) {
+			res = append(res, DeleteResult{ID: id, Error: ErrTestCaseNotFound.Error()})
+			continue
+		}
+		if err != nil {
+			r.log.Error("failed to get testcases from the DB", zap.String("cid", cid), zap.String("id", sanitiseInput(id)), zap.Error(err))
+			res = append(res, DeleteResult{ID: id, Error: "internal failure"})
+			continue
+		}
+		err = r.tdb.Delete(ctx, id)
+		if err != nil {
+			r.log.Error("failed to delete testcase from the DB", zap.String("cid", cid), zap.String("appID", t.AppID), zap.String("id", sanitiseInput(id)), zap.Error(err))
+			res = append(res, DeleteResult{ID: id, Error: "internal failure"})
+			continue
+		}
+		indexes[dedupIndex(&t)] = true
+		res = append(res, DeleteResult{ID: id, Deleted: true})
+		deleted++
+	}
+
+	for index := range indexes {
+		r.invalidateIndex(ctx, index)
+	}
+	if deleted > 0 {
+		r.tele.DeleteTc(r.client, ctx)
+	}
+	return res, nil
+}
+
+// GetApps returns the apps of the company sorted by name, so that their order is stable.
 func (r *Regression) GetApps(ctx context.Context, cid string) ([]string, error) {
 	apps, err := r.tdb.GetApps(ctx, cid)
+	sort.Strings(apps)
 	if apps != nil && len(apps) != r.appCount {
 		r.tele.GetApps(len(apps), r.client, ctx)
 		r.appCount = len(apps)
@@ -105,6 +214,9 @@ func sanitiseInput(s string) string {
 
 func (r *Regression) Get(ctx context.Context, cid, appID, id string) (models.TestCase, error) {
 	tcs, err := r.tdb.Get(ctx, cid, id)
+	if errors.Is(err, models.ErrNotFound) {
+		return models.TestCase{}, ErrTestCaseNotFound
+	}
 	if err != nil {
 		sanitizedAppID := sanitiseInput(appID)
 		r.log.Error("failed to get testcases from the DB", zap.String("cid", cid), zap.String("appID", sanitizedAppID), zap.Error(err))
@@ -113,7 +225,8 @@ func (r *Regression) Get(ctx context.Context, cid, appID, id string) (models.Tes
 	return tcs, nil
 }
 
-func (r *Regression) GetAll(ctx context.Context, cid, appID string, offset *int, limit *int) ([]models.TestCase, error) {
+// GetAll returns the testcases of the app, filtered by the given tags when there are any.
+func (r *Regression) GetAll(ctx context.Context, cid, appID string, tags []string, offset *int, limit *int) ([]models.TestCase, error) {
 	off, lim := 0, 25
 	if offset != nil {
 		off = *offset
@@ -122,7 +235,7 @@ func (r *Regression) GetAll(ctx context.Context, cid, appID string, offset *int,
 		lim = *limit
 	}
 
-	tcs, err := r.tdb.GetAll(ctx, cid, appID, false, off, lim)
+	tcs, err := r.tdb.GetAll(ctx, cid, appID, tags, false, off, lim)
 
 	if err != nil {
 		sanitizedAppID := sanitiseInput(appID)
@@ -132,7 +245,44 @@ func (r *Regression) GetAll(ctx context.Context, cid, appID string, offset *int,
 	return tcs, nil
 }
 
+// validMethods are the methods of a parseable request, an empty method is a GET.
+var validMethods = map[models.Method]bool{
+	"":                   true,
+	models.MethodGet:     true,
+	models.MethodPut:     true,
+	models.MethodHead:    true,
+	models.MethodPost:    true,
+	models.MethodPatch:   true,
+	models.MethodDelete:  true,
+	models.MethodOptions: true,
+	models.MethodTrace:   true,
+}
+
+// validateTC checks that the testcase can be indexed by the deduplication.
+func validateTC(t models.TestCase) error {
+	if t.ID == "" {
+		return errors.New("id is required")
+	}
+	if t.URI == "" {
+		return errors.New("uri is required")
+	}
+	if !validMethods[t.HttpReq.Method] {
+		return fmt.Errorf("invalid http method %q", t.HttpReq.Method)
+	}
+	if _, err := url.Parse(t.HttpReq.URL); err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	return nil
+}
+
+// UpdateTC updates the requests and responses of the testcases. They are all validated first,
+// so that none is updated when one is invalid.
 func (r *Regression) UpdateTC(ctx context.Context, t []models.TestCase) error {
+	for i, v := range t {
+		if err := validateTC(v); err != nil {
+			return fmt.Errorf("invalid testcase %d (id %q): %v", i, v.ID, err)
+		}
+	}
 	for _, v := range t {
 		err := r.tdb.UpdateTC(ctx, v)
 		if err != nil {
@@ -169,21 +319,35 @@ func (r *Regression) putTC(ctx context.Context, cid string, t models.TestCase) (
 	return t.ID, nil
 }
 
-func (r *Regression) Put(ctx context.Context, cid string, tcs []models.TestCase) ([]string, error) {
-	var ids []string
+// PutResult lists the testcases saved by Put and the ones skipped as duplicates.
+type PutResult struct {
+	// Inserted holds the ids of the saved testcases
+	Inserted []string `json:"inserted"`
+	// Duplicates holds the uris of the duplicate testcases
+	Duplicates []string `json:"duplicates"`
+}
+
+// Put saves the testcases in order, the duplicates are skipped when deduplication is enabled.
+// On failure the testcases saved until then are still returned.
+func (r *Regression) Put(ctx context.Context, cid string, tcs []models.TestCase) (PutResult, error) {
+	var res PutResult
 	if len(tcs) == 0 {
-		return ids, errors.New("no testcase to update")
+		return res, errors.New("no testcase to update")
 	}
 	for _, t := range tcs {
 		id, err := r.putTC(ctx, cid, t)
 		if err != nil {
 			msg := "failed saving testcase"
 			r.log.Error(msg, zap.Error(err), zap.String("cid", cid), zap.String("id", t.ID), zap.String("app", t.AppID))
-			return ids, errors.New(msg)
+			return res, errors.New(msg)
+		}
+		if id == "" {
+			res.Duplicates = append(res.Duplicates, t.URI)
+			continue
 		}
-		ids = append(ids, id)
+		res.Inserted = append(res.Inserted, id)
 	}
-	return ids, nil
+	return res, nil
 }
 
 func (r *Regression) test(ctx context.Context, cid, id, app string, resp models.HttpResp) (bool, *run.Result, *models.TestCase, error) {
@@ -193,9 +357,14 @@ func (r *Regression) test(ctx context.Context, cid, id, app string, resp models.
 		r.log.Error("failed to get testcase from DB", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
 		return false, nil, nil, err
 	}
+	// the gzip encoded bodies are compared decompressed
+	tc.HttpResp.Body = decodeBody(tc.HttpResp.Header, tc.HttpResp.Body)
+	resp.Body = decodeBody(resp.Header, resp.Body)
 	bodyType := run.BodyTypePlain
 	if json.Valid([]byte(resp.Body)) {
 		bodyType = run.BodyTypeJSON
+	} else if pkg.IsXML(resp.Body) {
+		bodyType = run.BodyTypeXML
 	}
 	pass := true
 	hRes := &[]run.HeaderResult{}
@@ -204,6 +373,7 @@ func (r *Regression) test(ctx context.Context, cid, id, app string, resp models.
 			Normal:   false,
 			Expected: tc.HttpResp.StatusCode,
 			Actual:   resp.StatusCode,
+			Class:    tc.StatusClass,
 		},
 		BodyResult: run.BodyResult{
 			Normal:   false,
@@ -218,7 +388,23 @@ func (r *Regression) test(ctx context.Context, cid, id, app string, resp models.
 		headerNoise = map[string]string{}
 	)
 
-	for _, n := range tc.Noise {
+	// the regex noise entries are matched against the keys of both the responses
+	expKeys, err := r.responseKeys(tc.HttpResp.Header, tc.HttpResp.Body)
+	if err != nil {
+		return false, res, &tc, err
+	}
+	actKeys, err := r.responseKeys(resp.Header, resp.Body)
+	if err != nil {
+		return false, res, &tc, err
+	}
+	noise, err := r.expandNoise(tc.Noise, expKeys, actKeys)
+	if err != nil {
+		return false, res, &tc, err
+	}
+	form := isForm(tc.HttpResp.Header) && isForm(resp.Header) && addForm(tc.HttpResp.Body, map[string][]string{}) == nil &&
+		addForm(resp.Body, map[string][]string{}) == nil
+
+	for _, n := range noise {
 		a := strings.Split(n, ".")
 		if len(a) > 1 && a[0] == "body" {
 			x := strings.Join(a[1:], ".")
@@ -233,37 +419,86 @@ func (r *Regression) test(ctx context.Context, cid, id, app string, resp models.
 		}
 	}
 
-	if !pkg.Contains(tc.Noise, "body") && bodyType == run.BodyTypeJSON {
-		pass, err = pkg.Match(tc.HttpResp.Body, resp.Body, bodyNoise, r.log)
+	if !pkg.Contains(noise, "body") && bodyType == run.BodyTypeJSON {
+		opts := pkg.MatchOptions{
+			UnorderedArrays: tc.UnorderedArrays,
+			Partial:         tc.PartialMatch,
+			AbsTolerance:    r.FloatAbsTolerance,
+			RelTolerance:    r.FloatRelTolerance,
+			MaxDepth:        r.MaxJSONDepth,
+		}
+		pass, err = pkg.MatchWithOptions(tc.HttpResp.Body, resp.Body, bodyNoise, opts, r.log)
+		if err != nil {
+			return false, res, &tc, err
+		}
+	} else if !pkg.Contains(noise, "body") && form {
+		// the url encoded forms are compared field by field
+		pass = len(bodyDiffs(expKeys, actKeys, noise, tc.PartialMatch)) == 0
+	} else if !pkg.Contains(noise, "body") && bodyType == run.BodyTypeXML && pkg.IsXML(tc.HttpResp.Body) {
+		// the XML noise fields are dotted paths from the root element, eg: body.alien.@id
+		pass, err = pkg.MatchXML(tc.HttpResp.Body, resp.Body, bodyNoise, r.MaxJSONDepth, r.log)
 		if err != nil {
 			return false, res, &tc, err
 		}
 	} else {
-		if !pkg.Contains(tc.Noise, "body") && tc.HttpResp.Body != resp.Body {
+		if !pkg.Contains(noise, "body") && tc.HttpResp.Body != resp.Body {
 			pass = false
 		}
 	}
 
 	res.BodyResult.Normal = pass
+	if !pass && (bodyType == run.BodyTypeJSON || form) {
+		res.BodyResult.Diffs = bodyDiffs(expKeys, actKeys, noise, tc.PartialMatch)
+	}
+	if r.SortBodyKeys && bodyType == run.BodyTypeJSON {
+		res.BodyResult.Expected = sortJSONKeys(res.BodyResult.Expected)
+		res.BodyResult.Actual = sortJSONKeys(res.BodyResult.Actual)
+	}
 
-	if !pkg.CompareHeaders(tc.HttpResp.Header, resp.Header, hRes, headerNoise) {
+	hOpts := pkg.HeaderOptions{
+		CaseInsensitive: r.CaseInsensitiveHeaders,
+		Ignore:          r.IgnoredHeaders,
+		Unordered:       r.UnorderedHeaders,
+		EmptyAsMissing:  r.EmptyAsMissingHeaders,
+	}
+	if !pkg.CompareHeadersWithOptions(tc.HttpResp.Header, resp.Header, hRes, headerNoise, hOpts) {
 		pass = false
 	}
 	res.HeadersResult = *hRes
 
-	if tc.HttpResp.StatusCode == resp.StatusCode {
+	if tc.HttpResp.StatusCode == resp.StatusCode || (tc.StatusClass && tc.HttpResp.StatusCode/100 == resp.StatusCode/100) {
 		res.StatusCode.Normal = true
 	} else {
 		pass = false
 	}
 
+	// the measured latency is always recorded, it is only asserted with a max latency. The server
+	// can't measure the requests, so a max latency fails the tests whose latency isn't reported.
+	if resp.Latency > 0 || tc.MaxLatency > 0 {
+		res.Latency = &run.IntResult{
+			Normal:   tc.MaxLatency <= 0 || (resp.Latency > 0 && resp.Latency <= tc.MaxLatency),
+			Expected: int(tc.MaxLatency),
+			Actual:   int(resp.Latency),
+		}
+		if !res.Latency.Normal {
+			pass = false
+		}
+	}
+
 	return pass, res, &tc, nil
 }
 
 func (r *Regression) Test(ctx context.Context, cid, app, runID, id string, resp models.HttpResp) (bool, error) {
-	var t *run.Test
 	started := time.Now().UTC()
 	ok, res, tc, err := r.test(ctx, cid, id, app, resp)
+	// the testcase is missing when it couldn't be read, the result is still saved as a failure
+	t := &run.Test{
+		ID:         uuid.New().String(),
+		Started:    started.Unix(),
+		RunID:      runID,
+		TestCaseID: id,
+		Resp:       resp,
+	}
 	if tc != nil {
 		t = &run.Test{
 			ID:         uuid.New().String(),
@@ -271,6 +506,7 @@ func (r *Regression) Test(ctx context.Context, cid, app, runID, id string, resp
 			RunID:      runID,
 			TestCaseID: id,
 			URI:        tc.URI,
+			Method:     tc.HttpReq.Method,
 			Req:        tc.HttpReq,
 			Dep:        tc.Deps,
 			Resp:       resp,
@@ -323,24 +559,13 @@ func (r *Regression) DeNoise(ctx context.Context, cid, id, app, body string, h h
 		return err
 	}
 
-	a, b := map[string][]string{}, map[string][]string{}
-
-	// add headers
-	for k, v := range tc.HttpResp.Header {
-		a["header."+k] = []string{strings.Join(v, "")}
-	}
-
-	for k, v := range h {
-		b["header."+k] = []string{strings.Join(v, "")}
-	}
-
-	err = addBody(tc.HttpResp.Body, a)
+	a, err := r.responseKeys(tc.HttpResp.Header, tc.HttpResp.Body)
 	if err != nil {
 		r.log.Error("failed to parse response body", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
 		return err
 	}
 
-	err = addBody(body, b)
+	b, err := r.responseKeys(h, body)
 	if err != nil {
 		r.log.Error("failed to parse response body", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
 		return err
@@ -358,7 +583,13 @@ func (r *Regression) DeNoise(ctx context.Context, cid, id, app, body string, h h
 		}
 	}
 	// r.log.Debug("Noise Array : ",zap.Any("",noise))
-	tc.Noise = noise
+	// the noise is accumulated over the calls, so that the fields found noisy earlier are kept
+	sort.Strings(noise)
+	tc.Noise, err = r.mergeNoise(tc.Noise, noise)
+	if err != nil {
+		r.log.Error("failed to parse noise fields", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
+		return err
+	}
 	err = r.tdb.Upsert(ctx, tc)
 	if err != nil {
 		r.log.Error("failed to update noise fields for testcase", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
@@ -367,7 +598,143 @@ func (r *Regression) DeNoise(ctx context.Context, cid, id, app, body string, h h
 	return nil
 }
 
-func addBody(body string, m map[string][]string) error {
+// DeNoiseRequest marks the fields that vary between the stored request of the testcase and the given
+// one, eg: a timestamp or a nonce, as noisy. They are stored in the noise of the testcase with the
+// "req." prefix, eg: req.body.ts, and are excluded from the anchors of the deduplication.
+func (r *Regression) DeNoiseRequest(ctx context.Context, cid, id, app string, req models.HttpReq) error {
+	tc, err := r.tdb.Get(ctx, cid, id)
+	if err != nil {
+		r.log.Error("failed to get testcase from DB", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
+		return err
+	}
+
+	a, err := r.requestKeys(tc.HttpReq)
+	if err != nil {
+		r.log.Error("failed to parse request body", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
+		return err
+	}
+	b, err := r.requestKeys(req)
+	if err != nil {
+		r.log.Error("failed to parse request body", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
+		return err
+	}
+	var noise []string
+	for k, v := range a {
+		if v2, ok := b[k]; !ok || !reflect.DeepEqual(v, v2) {
+			noise = append(noise, requestNoisePrefix+k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			noise = append(noise, requestNoisePrefix+k)
+		}
+	}
+	sort.Strings(noise)
+	tc.Noise, err = r.mergeNoise(tc.Noise, noise)
+	if err != nil {
+		r.log.Error("failed to parse noise fields", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
+		return err
+	}
+	err = r.tdb.Upsert(ctx, tc)
+	if err != nil {
+		r.log.Error("failed to update noise fields for testcase", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
+		return err
+	}
+
+	// the cached statistics of the index are reloaded with the new noise
+	index := dedupIndex(&tc)
+	l := r.indexLock(index)
+	l.Lock()
+	defer l.Unlock()
+	r.mu.Lock()
+	delete(r.anchors, index)
+	delete(r.noisyFields, index)
+	delete(r.fieldCounts, index)
+	r.mu.Unlock()
+	r.dropCache(ctx, index)
+	return nil
+}
+
+// DeNoiseSamples marks the fields that vary between the stored response of the testcase and any of
+// the sample responses as noisy. The noisy fields are merged into the existing ones of the testcase.
+func (r *Regression) DeNoiseSamples(ctx context.Context, cid, id, app string, samples []models.HttpResp) error {
+	if len(samples) == 0 {
+		return errors.New("no samples to denoise")
+	}
+	tc, err := r.tdb.Get(ctx, cid, id)
+	if err != nil {
+		r.log.Error("failed to get testcase from DB", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
+		return err
+	}
+
+	a, err := r.responseKeys(tc.HttpResp.Header, tc.HttpResp.Body)
+	if err != nil {
+		r.log.Error("failed to parse response body", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
+		return err
+	}
+	var keys []map[string][]string
+	for _, s := range samples {
+		b, err := r.responseKeys(s.Header, s.Body)
+		if err != nil {
+			r.log.Error("failed to parse response body", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
+			return err
+		}
+		keys = append(keys, b)
+	}
+
+	// a field varies across a pair of responses iff it differs from the stored response in one of them
+	var noise []string
+	for k, v := range a {
+		for _, b := range keys {
+			if v2, ok := b[k]; !ok || !reflect.DeepEqual(v, v2) {
+				noise = append(noise, k)
+				break
+			}
+		}
+	}
+	for _, b := range keys {
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				noise = append(noise, k)
+			}
+		}
+	}
+
+	sort.Strings(noise)
+	tc.Noise, err = r.mergeNoise(tc.Noise, noise)
+	if err != nil {
+		r.log.Error("failed to parse noise fields", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
+		return err
+	}
+	err = r.tdb.Upsert(ctx, tc)
+	if err != nil {
+		r.log.Error("failed to update noise fields for testcase", zap.String("id", id), zap.String("cid", cid), zap.String("appID", app), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// sortJSONKeys re-encodes the given JSON string with object keys sorted at every level.
+// The input is returned as it is if it cannot be parsed.
+func sortJSONKeys(s string) string {
+	dec := json.NewDecoder(strings.NewReader(s))
+	// preserve the textual form of numbers instead of converting them to float64
+	dec.UseNumber()
+	var result interface{}
+	if err := dec.Decode(&result); err != nil {
+		return s
+	}
+	// encoding/json writes map keys in sorted order
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(result); err != nil {
+		return s
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func (r *Regression) addBody(body string, m map[string][]string) error {
 	// add body
 	if json.Valid([]byte(body)) {
 		var result interface{}
@@ -376,7 +743,10 @@ func addBody(body string, m map[string][]string) error {
 		if err != nil {
 			return err
 		}
-		j := flatten(result)
+		if err = pkg.CheckDepth(result, r.MaxJSONDepth); err != nil {
+			return err
+		}
+		j := flattenKeys(result, r.IndexArrays)
 		for k, v := range j {
 			nk := "body"
 			if k != "" {
@@ -395,8 +765,20 @@ func addBody(body string, m map[string][]string) error {
 // by dot-delimited keys.
 // examples of valid jsons - https://developer.mozilla.org/en-US/docs/Web/JavaScript/Reference/Global_Objects/JSON/parse#examples
 func flatten(j interface{}) map[string][]string {
+	return flattenKeys(j, false)
+}
+
+// nullValue is the flattened value of a JSON null, so that {"x": null} and {"x": ""} differ. A JSON
+// string only holds the NUL character when it is escaped, eg: "\u0000null", so it doesn't clash
+// with the real values.
+const nullValue = "\x00null"
+
+// flattenKeys flattens j like flatten. If indexed is set, the index of an array element is a
+// segment of its keys, eg: items.0.name, otherwise the values of all the elements are merged
+// under the same key, eg: items.name.
+func flattenKeys(j interface{}, indexed bool) map[string][]string {
 	if j == nil {
-		return map[string][]string{"": {""}}
+		return map[string][]string{"": {nullValue}}
 	}
 	o := make(map[string][]string)
 	x := reflect.ValueOf(j)
@@ -407,7 +789,7 @@ func flatten(j interface{}) map[string][]string {
 			return map[string][]string{}
 		}
 		for k, v := range m {
-			nm := flatten(v)
+			nm := flattenKeys(v, indexed)
 			for nk, nv := range nm {
 				fk := k
 				if nk != "" {
@@ -419,7 +801,8 @@ func flatten(j interface{}) map[string][]string {
 	case reflect.Bool:
 		o[""] = []string{strconv.FormatBool(x.Bool())}
 	case reflect.Float64:
-		o[""] = []string{strconv.FormatFloat(x.Float(), 'E', -1, 64)}
+		// 'f' keeps the textual form of the source JSON, 'E' turned 90000 into 9E+04
+		o[""] = []string{strconv.FormatFloat(x.Float(), 'f', -1, 64)}
 	case reflect.String:
 		o[""] = []string{x.String()}
 	case reflect.Slice:
@@ -427,9 +810,17 @@ func flatten(j interface{}) map[string][]string {
 		if !ok {
 			return map[string][]string{}
 		}
-		for _, av := range child {
-			nm := flatten(av)
+		for i, av := range child {
+			nm := flattenKeys(av, indexed)
 			for nk, nv := range nm {
+				if indexed {
+					ik := strconv.Itoa(i)
+					if nk != "" {
+						ik = ik + "." + nk
+					}
+					o[ik] = nv
+					continue
+				}
 				if ov, exists := o[nk]; exists {
 					o[nk] = append(ov, nv...)
 				} else {
@@ -443,35 +834,62 @@ func flatten(j interface{}) map[string][]string {
 	return o
 }
 
-func (r *Regression) fillCache(ctx context.Context, t *models.TestCase) (string, error) {
-
-	index := fmt.Sprintf("%s-%s-%s", t.CID, t.AppID, t.URI)
-	_, ok1 := r.noisyFields[index]
-	_, ok2 := r.fieldCounts[index]
-	if ok1 && ok2 {
-		return index, nil
-	}
+// dedupIndex returns the index of the deduplication caches of the testcase. The method is part of
+// it, so that eg: GET and POST on the same uri don't share their anchors and noise statistics.
+func dedupIndex(t *models.TestCase) string {
+	return fmt.Sprintf("%s-%s-%s-%s", t.CID, t.AppID, t.HttpReq.Method, t.URI)
+}
 
+// indexLock returns the lock of the index, creating it on the first use.
+func (r *Regression) indexLock(index string) *sync.Mutex {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	l, ok := r.indexLocks[index]
+	if !ok {
+		l = &sync.Mutex{}
+		r.indexLocks[index] = l
+	}
+	return l
+}
 
-	// check again after the lock
-	_, ok1 = r.noisyFields[index]
-	_, ok2 = r.fieldCounts[index]
+// fillCache loads the anchors and field counts of the index from the DB, if they aren't cached yet.
+// The index lock must be held by the caller.
+func (r *Regression) fillCache(ctx context.Context, index string, t *models.TestCase) error {
+	r.mu.Lock()
+	_, ok1 := r.noisyFields[index]
+	_, ok2 := r.fieldCounts[index]
+	r.mu.Unlock()
 
+	if (!ok1 || !ok2) && r.loadCache(ctx, index) {
+		return nil
+	}
 	if !ok1 || !ok2 {
 		var anchors []map[string][]string
 		fieldCounts, noisyFields := map[string]map[string]int{}, map[string]bool{}
-		tcs, err := r.tdb.GetKeys(ctx, t.CID, t.AppID, t.URI)
+		tcs, err := r.tdb.GetKeys(ctx, t.CID, t.AppID, t.URI, t.HttpReq.Method)
 		if err != nil {
-			return "", err
+			return err
+		}
+		// the request fields denoised on any testcase are never anchors
+		for _, v := range tcs {
+			for _, n := range v.Noise {
+				if strings.HasPrefix(n, requestNoisePrefix) {
+					noisyFields[strings.TrimPrefix(n, requestNoisePrefix)] = true
+				}
+			}
 		}
 		for _, v := range tcs {
 			//var appAnchors map[string][]string
 			//for _, a := range v.Anchors {
 			//	appAnchors[a] = v.AllKeys[a]
 			//}
-			anchors = append(anchors, v.Anchors)
+			a := map[string][]string{}
+			for k, v1 := range v.Anchors {
+				if !noisyFields[k] {
+					a[k] = v1
+				}
+			}
+			anchors = append(anchors, a)
 			for k, v1 := range v.AllKeys {
 				if fieldCounts[k] == nil {
 					fieldCounts[k] = map[string]int{}
@@ -479,45 +897,131 @@ func (r *Regression) fillCache(ctx context.Context, t *models.TestCase) (string,
 				for _, v2 := range v1 {
 					fieldCounts[k][v2] = fieldCounts[k][v2] + 1
 				}
-				if !isAnchor(fieldCounts[k]) {
+				if !r.isAnchor(fieldCounts[k]) {
 					noisyFields[k] = true
 				}
 			}
 		}
+		r.mu.Lock()
 		r.fieldCounts[index], r.noisyFields[index], r.anchors[index] = fieldCounts, noisyFields, anchors
+		r.mu.Unlock()
 	}
-	return index, nil
+	return nil
 }
 
-func (r *Regression) isDup(ctx context.Context, t *models.TestCase) (bool, error) {
+// loadCache reads the persisted cache of the index into memory, it returns false when there is none.
+// The caller holds the index lock.
+func (r *Regression) loadCache(ctx context.Context, index string) bool {
+	if r.CacheDB == nil {
+		return false
+	}
+	c, err := r.CacheDB.Get(ctx, index)
+	if err != nil {
+		r.log.Error("failed to read the deduplication cache from DB", zap.String("index", sanitiseInput(index)), zap.Error(err))
+		return false
+	}
+	if c == nil {
+		return false
+	}
+	fieldCounts := map[string]map[string]int{}
+	for _, f := range c.FieldCounts {
+		if fieldCounts[f.Key] == nil {
+			fieldCounts[f.Key] = map[string]int{}
+		}
+		fieldCounts[f.Key][f.Value] = f.Count
+	}
+	if c.NoisyFields == nil {
+		c.NoisyFields = map[string]bool{}
+	}
+	r.mu.Lock()
+	r.fieldCounts[index], r.noisyFields[index], r.anchors[index] = fieldCounts, c.NoisyFields, c.Anchors
+	r.mu.Unlock()
+	return true
+}
 
-	reqKeys := map[string][]string{}
-	filterKeys := map[string][]string{}
+// saveCache persists the cache of the index, a failure is only logged since the cache can always be
+// rebuilt. The caller holds the index lock.
+func (r *Regression) saveCache(ctx context.Context, index string, t *models.TestCase) {
+	if r.CacheDB == nil {
+		return
+	}
+	c := models.DedupCache{Index: index, CID: t.CID, AppID: t.AppID}
+	r.mu.Lock()
+	c.Anchors, c.NoisyFields = r.anchors[index], r.noisyFields[index]
+	for k, counts := range r.fieldCounts[index] {
+		for v, n := range counts {
+			c.FieldCounts = append(c.FieldCounts, models.FieldCount{Key: k, Value: v, Count: n})
+		}
+	}
+	r.mu.Unlock()
+	if err := r.CacheDB.Put(ctx, c); err != nil {
+		r.log.Error("failed to save the deduplication cache in DB", zap.String("index", sanitiseInput(index)), zap.Error(err))
+	}
+}
 
-	index, err := r.fillCache(ctx, t)
-	if err != nil {
-		return false, err
+// invalidateIndex drops the cache of the index, in memory and in the DB. It holds the lock of the
+// index, so that an isDup in flight can't save the stale cache back after it is dropped.
+func (r *Regression) invalidateIndex(ctx context.Context, index string) {
+	l := r.indexLock(index)
+	l.Lock()
+	defer l.Unlock()
+	r.mu.Lock()
+	delete(r.anchors, index)
+	delete(r.noisyFields, index)
+	delete(r.fieldCounts, index)
+	r.mu.Unlock()
+	r.dropCache(ctx, index)
+}
+
+// dropCache deletes the persisted cache of the index, so that it is rebuilt from the testcases.
+func (r *Regression) dropCache(ctx context.Context, index string) {
+	if r.CacheDB == nil {
+		return
+	}
+	if err := r.CacheDB.Delete(ctx, index); err != nil {
+		r.log.Error("failed to delete the deduplication cache from DB", zap.String("index", sanitiseInput(index)), zap.Error(err))
 	}
+}
 
+// requestKeys returns the flattened keys of the headers, the url params and the body of a request.
+// A body larger than MaxDedupBodySize isn't parsed, its hash is the single value of the body key.
+func (r *Regression) requestKeys(req models.HttpReq) (map[string][]string, error) {
+	reqKeys := map[string][]string{}
 	// add headers
-	for k, v := range t.HttpReq.Header {
+	for k, v := range req.Header {
 		reqKeys["header."+k] = []string{strings.Join(v, "")}
 	}
 
-	// add url params
-	for k, v := range t.HttpReq.URLParams {
-		reqKeys["url_params."+k] = []string{v}
+	// add url params, the query of the url holds every value of a repeated param (eg: ?a=1&a=2)
+	// while URLParams only holds one. The values are sorted so that their order doesn't matter.
+	params := map[string][]string{}
+	for k, v := range req.URLParams {
+		params[k] = []string{v}
+	}
+	if u, err := url.Parse(req.URL); err == nil {
+		for k, v := range u.Query() {
+			params[k] = v
+		}
+	}
+	for k, v := range params {
+		reqKeys["url_params."+k] = sortedCopy(v)
 	}
 
 	// add body if it is a valid json
-	if json.Valid([]byte(t.HttpReq.Body)) {
+	if len(req.Body) > r.MaxDedupBodySize {
+		sum := sha256.Sum256([]byte(req.Body))
+		reqKeys["body"] = []string{hex.EncodeToString(sum[:])}
+	} else if json.Valid([]byte(req.Body)) {
 		var result interface{}
 
-		err = json.Unmarshal([]byte(t.HttpReq.Body), &result)
+		err := json.Unmarshal([]byte(req.Body), &result)
 		if err != nil {
-			return false, err
+			return nil, err
+		}
+		if err = pkg.CheckDepth(result, r.MaxJSONDepth); err != nil {
+			return nil, err
 		}
-		body := flatten(result)
+		body := flattenKeys(result, r.IndexArrays)
 		for k, v := range body {
 			nk := "body"
 			if k != "" {
@@ -525,35 +1029,231 @@ func (r *Regression) isDup(ctx context.Context, t *models.TestCase) (bool, error
 			}
 			reqKeys[nk] = v
 		}
+	} else if isForm(req.Header) {
+		// an invalid form is ignored like any other body which isn't json
+		form := map[string][]string{}
+		if addForm(req.Body, form) == nil {
+			for k, v := range form {
+				reqKeys[k] = v
+			}
+		}
 	}
+	return reqKeys, nil
+}
 
-	isAnchorChange := true
+// ResetCache drops the cached deduplication statistics of every index of the app, they are
+// rebuilt from the DB on the next deduplication, eg: after the testcases were edited in bulk.
+//
+// The indexes of the app are locked until the cache is deleted from the DB too, so that an isDup
+// in flight can't save the stale cache back. The locked indexes include the ones whose cache isn't
+// filled yet, since their lock is created before the cache is loaded.
+func (r *Regression) ResetCache(cid, appID string) {
+	prefix := fmt.Sprintf("%s-%s-", cid, appID)
+	seen := map[string]bool{}
+	var indexes []string
+	r.mu.Lock()
+	for _, m := range []map[string]bool{indexKeys(r.indexLocks), indexKeys(r.anchors), indexKeys(r.noisyFields), indexKeys(r.fieldCounts)} {
+		for index := range m {
+			if seen[index] || !strings.HasPrefix(index, prefix) {
+				continue
+			}
+			// the index of an app whose id starts with "appID-" has the same prefix
+			method := strings.SplitN(strings.TrimPrefix(index, prefix), "-", 2)[0]
+			if !validMethods[models.Method(method)] {
+				continue
+			}
+			seen[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+	r.mu.Unlock()
+
+	// a single order, so that two resets of the app can't deadlock
+	sort.Strings(indexes)
+	for _, index := range indexes {
+		l := r.indexLock(index)
+		l.Lock()
+		defer l.Unlock()
+	}
+	r.mu.Lock()
+	for _, index := range indexes {
+		delete(r.anchors, index)
+		delete(r.noisyFields, index)
+		delete(r.fieldCounts, index)
+	}
+	r.mu.Unlock()
+	if r.CacheDB != nil {
+		if err := r.CacheDB.DeleteApp(context.TODO(), cid, appID); err != nil {
+			r.log.Error("failed to delete the deduplication cache from DB", zap.String("cid", cid), zap.String("appID", sanitiseInput(appID)), zap.Error(err))
+		}
+	}
+}
+
+// indexKeys returns the set of indexes of a cache map.
+func indexKeys[V any](m map[string]V) map[string]bool {
+	res := map[string]bool{}
+	for k := range m {
+		res[k] = true
+	}
+	return res
+}
+
+// NoisyFields returns the sorted request fields which the deduplication ignores for the testcases
+// of the given app, method and uri. The cache of the index is built if it is cold.
+func (r *Regression) NoisyFields(ctx context.Context, cid, app string, method models.Method, uri string) ([]string, error) {
+	t := &models.TestCase{CID: cid, AppID: app, URI: uri, HttpReq: models.HttpReq{Method: method}}
+	index := dedupIndex(t)
+	l := r.indexLock(index)
+	l.Lock()
+	defer l.Unlock()
+
+	err := r.fillCache(ctx, index, t)
+	if err != nil {
+		r.log.Error("failed to get noisy fields", zap.String("cid", cid), zap.String("appID", sanitiseInput(app)), zap.String("uri", sanitiseInput(uri)), zap.Error(err))
+		return nil, errors.New("internal failure")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fields := []string{}
+	for k, noisy := range r.noisyFields[index] {
+		if noisy {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// countKeys counts the values of the request keys which aren't noisy, the keys becoming high
+// variance are marked as noisy. It returns the anchors of the request, ie: the remaining keys.
+func (r *Regression) countKeys(reqKeys map[string][]string, noisyFields map[string]bool, fieldCounts map[string]map[string]int) map[string][]string {
+	filterKeys := map[string][]string{}
 	for k, v := range reqKeys {
-		if !r.noisyFields[index][k] {
+		if !noisyFields[k] {
 			// update field count
 			for _, s := range v {
-				if _, ok := r.fieldCounts[index][k]; !ok {
-					r.fieldCounts[index][k] = map[string]int{}
+				if _, ok := fieldCounts[k]; !ok {
+					fieldCounts[k] = map[string]int{}
 				}
-				r.fieldCounts[index][k][s] = r.fieldCounts[index][k][s] + 1
+				fieldCounts[k][s] = fieldCounts[k][s] + 1
 			}
-			if !isAnchor(r.fieldCounts[index][k]) {
-				r.noisyFields[index][k] = true
-				isAnchorChange = true
+			if !r.isAnchor(fieldCounts[k]) {
+				noisyFields[k] = true
 				continue
 			}
 			filterKeys[k] = v
 		}
 	}
+	return filterKeys
+}
 
-	if len(filterKeys) == 0 {
-		return true, nil
+// DedupReport tells whether Put would skip a testcase as a duplicate.
+type DedupReport struct {
+	ID        string `json:"id"`
+	URI       string `json:"uri"`
+	Duplicate bool   `json:"duplicate"`
+	// Anchors are the anchor fields of the request, a duplicate has the same anchors as a saved
+	// testcase
+	Anchors map[string][]string `json:"anchors"`
+}
+
+// DryRunPut reports which of the testcases Put would skip as duplicates, as if deduplication were
+// enabled. Nothing is saved and the caches are left untouched, they are only filled from the DB
+// when they are cold. The testcases are deduplicated in order, so one can duplicate an earlier one.
+func (r *Regression) DryRunPut(ctx context.Context, cid string, tcs []models.TestCase) ([]DedupReport, error) {
+	type state struct {
+		anchors     []map[string][]string
+		noisyFields map[string]bool
+		fieldCounts map[string]map[string]int
 	}
-	if isAnchorChange {
-		err = r.tdb.DeleteByAnchor(ctx, t.CID, t.AppID, t.URI, filterKeys)
+	states := map[string]*state{}
+	var res []DedupReport
+	for _, t := range tcs {
+		t.CID = cid
+		index := dedupIndex(&t)
+		s, ok := states[index]
+		if !ok {
+			l := r.indexLock(index)
+			l.Lock()
+			err := r.fillCache(ctx, index, &t)
+			if err != nil {
+				l.Unlock()
+				r.log.Error("failed to run deduplication on the testcase", zap.String("cid", cid), zap.String("appID", t.AppID), zap.Error(err))
+				return nil, errors.New("internal failure")
+			}
+			// the simulation only changes copies of the caches
+			r.mu.Lock()
+			s = &state{
+				anchors:     append([]map[string][]string{}, r.anchors[index]...),
+				noisyFields: map[string]bool{},
+				fieldCounts: map[string]map[string]int{},
+			}
+			for k, v := range r.noisyFields[index] {
+				s.noisyFields[k] = v
+			}
+			for k, counts := range r.fieldCounts[index] {
+				s.fieldCounts[k] = map[string]int{}
+				for v, n := range counts {
+					s.fieldCounts[k][v] = n
+				}
+			}
+			r.mu.Unlock()
+			l.Unlock()
+			states[index] = s
+		}
+
+		reqKeys, err := r.requestKeys(t.HttpReq)
 		if err != nil {
-			return false, err
+			r.log.Error("failed to run deduplication on the testcase", zap.String("cid", cid), zap.String("appID", t.AppID), zap.Error(err))
+			return nil, errors.New("internal failure")
 		}
+		filterKeys := r.countKeys(reqKeys, s.noisyFields, s.fieldCounts)
+		report := DedupReport{ID: t.ID, URI: t.URI, Anchors: filterKeys, Duplicate: len(filterKeys) == 0}
+		if !report.Duplicate {
+			for _, v := range filterKeys {
+				sort.Strings(v)
+			}
+			for _, a := range s.anchors {
+				if reflect.DeepEqual(a, filterKeys) {
+					report.Duplicate = true
+					break
+				}
+			}
+			s.anchors = append(s.anchors, filterKeys)
+		}
+		res = append(res, report)
+	}
+	return res, nil
+}
+
+func (r *Regression) isDup(ctx context.Context, t *models.TestCase) (bool, error) {
+	index := dedupIndex(t)
+	l := r.indexLock(index)
+	l.Lock()
+	defer l.Unlock()
+
+	err := r.fillCache(ctx, index, t)
+	if err != nil {
+		return false, err
+	}
+	// the field counts change on every deduplication
+	defer r.saveCache(ctx, index, t)
+	r.mu.Lock()
+	noisyFields, fieldCounts := r.noisyFields[index], r.fieldCounts[index]
+	r.mu.Unlock()
+
+	reqKeys, err := r.requestKeys(t.HttpReq)
+	if err != nil {
+		return false, err
+	}
+
+	filterKeys := r.countKeys(reqKeys, noisyFields, fieldCounts)
+	if len(filterKeys) == 0 {
+		return true, nil
+	}
+	err = r.tdb.DeleteByAnchor(ctx, t.CID, t.AppID, t.URI, t.HttpReq.Method, filterKeys)
+	if err != nil {
+		return false, err
 	}
 
 	// check if testcase based on anchor keys already exists
@@ -568,7 +1268,9 @@ func (r *Regression) isDup(ctx context.Context, t *models.TestCase) (bool, error
 	//	keys = append(keys, k)
 	//}
 	t.Anchors = filterKeys
+	r.mu.Lock()
 	r.anchors[index] = append(r.anchors[index], filterKeys)
+	r.mu.Unlock()
 
 	return dup, nil
 }
@@ -577,6 +1279,8 @@ func (r *Regression) exists(_ context.Context, anchors map[string][]string, inde
 	for _, v := range anchors {
 		sort.Strings(v)
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	for _, v := range r.anchors[index] {
 		if reflect.DeepEqual(v, anchors) {
 			return true, nil
@@ -586,19 +1290,19 @@ func (r *Regression) exists(_ context.Context, anchors map[string][]string, inde
 
 }
 
-func isAnchor(m map[string]int) bool {
+func (r *Regression) isAnchor(m map[string]int) bool {
 	totalCount := 0
 	for _, v := range m {
 		totalCount = totalCount + v
 	}
-	// if total values for that field is less than 20 then,
+	// if total values for that field is less than AnchorMinSamples (20 by default) then,
 	// the sample size is too small to know if its high variance.
-	if totalCount < 20 {
+	if totalCount < r.AnchorMinSamples {
 		return true
 	}
-	// if the unique values are less than 40% of the total value count them,
+	// if the unique values are less than AnchorMaxUniqueRatio (40% by default) of the total value count them,
 	// the field is low variant.
-	if float64(totalCount)*0.40 > float64(len(m)) {
+	if float64(totalCount)*r.AnchorMaxUniqueRatio > float64(len(m)) {
 		return true
 	}
 	return false
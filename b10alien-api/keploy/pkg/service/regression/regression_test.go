@@ -0,0 +1,1586 @@
+package regression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.keploy.io/server/pkg"
+	"go.keploy.io/server/pkg/models"
+	"go.keploy.io/server/pkg/platform/telemetry"
+	"go.keploy.io/server/pkg/service/run"
+	"go.uber.org/zap"
+)
+
+// mockTestCaseDB is an in-memory implementation of models.TestCaseDB, safe for concurrent use.
+type mockTestCaseDB struct {
+	mu  sync.Mutex
+	tcs map[string]models.TestCase
+	// deleteErrs fails the deletion of the testcases
+	deleteErrs map[string]error
+}
+
+func newMockTestCaseDB(tcs ...models.TestCase) *mockTestCaseDB {
+	m := &mockTestCaseDB{tcs: map[string]models.TestCase{}}
+	for _, tc := range tcs {
+		m.tcs[tc.ID] = tc
+	}
+	return m
+}
+
+func (m *mockTestCaseDB) Upsert(_ context.Context, tc models.TestCase) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tcs[tc.ID] = tc
+	return nil
+}
+
+func (m *mockTestCaseDB) UpdateTC(_ context.Context, tc models.TestCase) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old := m.tcs[tc.ID]
+	old.HttpReq, old.HttpResp = tc.HttpReq, tc.HttpResp
+	m.tcs[tc.ID] = old
+	return nil
+}
+
+func (m *mockTestCaseDB) Get(_ context.Context, cid, id string) (models.TestCase, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tc, ok := m.tcs[id]
+	if !ok || (cid != "" && tc.CID != cid) {
+		return models.TestCase{}, models.ErrNotFound
+	}
+	return tc, nil
+}
+
+func (m *mockTestCaseDB) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.deleteErrs[id]; err != nil {
+		return err
+	}
+	delete(m.tcs, id)
+	return nil
+}
+
+func (m *mockTestCaseDB) GetAll(_ context.Context, cid, app string, tags []string, _ bool, _ int, _ int) ([]models.TestCase, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var res []models.TestCase
+	for _, tc := range m.tcs {
+		if tc.CID == cid && tc.AppID == app && hasTags(tc, tags) {
+			res = append(res, tc)
+		}
+	}
+	return res, nil
+}
+
+// hasTags reports whether the testcase has all the tags, like the $all query of mongo.
+func hasTags(tc models.TestCase, tags []string) bool {
+	for _, t := range tags {
+		if !pkg.Contains(tc.Tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *mockTestCaseDB) GetKeys(_ context.Context, cid, app, uri string, method models.Method) ([]models.TestCase, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var res []models.TestCase
+	for _, tc := range m.tcs {
+		if tc.CID == cid && tc.AppID == app && tc.URI == uri && tc.HttpReq.Method == method {
+			res = append(res, tc)
+		}
+	}
+	return res, nil
+}
+
+func (m *mockTestCaseDB) DeleteByAnchor(_ context.Context, _, _, _ string, _ models.Method, _ map[string][]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return nil
+}
+
+func (m *mockTestCaseDB) GetApps(_ context.Context, cid string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := map[string]bool{}
+	var apps []string
+	for _, tc := range m.tcs {
+		if tc.CID == cid && !seen[tc.AppID] {
+			seen[tc.AppID] = true
+			apps = append(apps, tc.AppID)
+		}
+	}
+	return apps, nil
+}
+
+// mockRunDB is an in-memory implementation of run.DB.
+type mockRunDB struct {
+	runs  map[string]run.TestRun
+	tests map[string]run.Test
+}
+
+func newMockRunDB() *mockRunDB {
+	return &mockRunDB{runs: map[string]run.TestRun{}, tests: map[string]run.Test{}}
+}
+
+func (m *mockRunDB) Read(_ context.Context, cid string, _, _, id *string, _, _ *time.Time, _ int, _ int) ([]*run.TestRun, error) {
+	var res []*run.TestRun
+	for _, tr := range m.runs {
+		if tr.CID != cid || (id != nil && tr.ID != *id) {
+			continue
+		}
+		tr := tr
+		res = append(res, &tr)
+	}
+	return res, nil
+}
+
+func (m *mockRunDB) Upsert(_ context.Context, tr run.TestRun) error {
+	m.runs[tr.ID] = tr
+	return nil
+}
+
+func (m *mockRunDB) ReadTest(_ context.Context, id string) (run.Test, error) {
+	t, ok := m.tests[id]
+	if !ok {
+		return t, errors.New("no documents in result")
+	}
+	return t, nil
+}
+
+func (m *mockRunDB) ReadTests(_ context.Context, runID string) ([]run.Test, error) {
+	var res []run.Test
+	for _, t := range m.tests {
+		if t.RunID == runID {
+			res = append(res, t)
+		}
+	}
+	return res, nil
+}
+
+func (m *mockRunDB) Stats(_ context.Context, runID string) (run.TestStats, error) {
+	var stats run.TestStats
+	for _, t := range m.tests {
+		if t.RunID != runID {
+			continue
+		}
+		switch t.Status {
+		case run.TestStatusPassed:
+			stats.Passed++
+		case run.TestStatusFailed:
+			stats.Failed++
+		case run.TestStatusRunning:
+			stats.Running++
+		case run.TestStatusPending:
+			stats.Pending++
+		}
+	}
+	return stats, nil
+}
+
+func (m *mockRunDB) DeleteTest(_ context.Context, id string) error {
+	if _, ok := m.tests[id]; !ok {
+		return errors.New("document not found")
+	}
+	delete(m.tests, id)
+	return nil
+}
+
+func (m *mockRunDB) DeleteRun(_ context.Context, runID string) (int64, error) {
+	var count int64
+	for id, t := range m.tests {
+		if t.RunID == runID {
+			delete(m.tests, id)
+			count++
+		}
+	}
+	delete(m.runs, runID)
+	return count, nil
+}
+
+func (m *mockRunDB) DeleteBefore(_ context.Context, _ string, _ time.Time) (int64, int64, error) {
+	return 0, 0, nil
+}
+
+func (m *mockRunDB) PutTest(_ context.Context, t run.Test) error {
+	m.tests[t.ID] = t
+	return nil
+}
+
+func (m *mockRunDB) Increment(_ context.Context, success, failure bool, id string) error {
+	tr := m.runs[id]
+	tr.ID = id
+	if success {
+		tr.Success++
+	}
+	if failure {
+		tr.Failure++
+	}
+	m.runs[id] = tr
+	return nil
+}
+
+// mockTelemetry records the number of telemetry events it receives.
+type mockTelemetry struct {
+	events int
+}
+
+func (m *mockTelemetry) Ping(bool)                                      {}
+func (m *mockTelemetry) Normalize(http.Client, context.Context)         { m.events++ }
+func (m *mockTelemetry) EditTc(http.Client, context.Context)            { m.events++ }
+func (m *mockTelemetry) Testrun(int, int, http.Client, context.Context) { m.events++ }
+func (m *mockTelemetry) DeleteTc(http.Client, context.Context)          { m.events++ }
+func (m *mockTelemetry) GetApps(int, http.Client, context.Context)      { m.events++ }
+
+// countingTransport counts the requests sent through it, without sending them.
+type countingTransport struct {
+	calls int
+}
+
+func (c *countingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	c.calls++
+	return nil, errors.New("no network in tests")
+}
+
+type mockCacheDB struct {
+	caches map[string]models.DedupCache
+}
+
+func (m *mockCacheDB) Get(_ context.Context, index string) (*models.DedupCache, error) {
+	c, ok := m.caches[index]
+	if !ok {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+func (m *mockCacheDB) Put(_ context.Context, c models.DedupCache) error {
+	m.caches[c.Index] = c
+	return nil
+}
+
+func (m *mockCacheDB) Delete(_ context.Context, index string) error {
+	delete(m.caches, index)
+	return nil
+}
+
+func (m *mockCacheDB) DeleteApp(_ context.Context, cid, app string) error {
+	for k, c := range m.caches {
+		if c.CID == cid && c.AppID == app {
+			delete(m.caches, k)
+		}
+	}
+	return nil
+}
+
+func newTestRegression(tcs ...models.TestCase) *Regression {
+	logger, _ := zap.NewDevelopment()
+	return New(newMockTestCaseDB(tcs...), newMockRunDB(), logger, false, &mockTelemetry{}, http.Client{})
+}
+
+func TestTelemetryOff(t *testing.T) {
+	t.Setenv(telemetry.EnvTelemetry, "off")
+	logger, _ := zap.NewDevelopment()
+	tele, tr := &mockTelemetry{}, &countingTransport{}
+	tdb := newMockTestCaseDB(models.TestCase{ID: "1", CID: "cid", AppID: "app", URI: "/b10aliens"})
+	r := New(tdb, newMockRunDB(), logger, false, tele, http.Client{Transport: tr})
+
+	if _, err := r.GetApps(context.Background(), "cid"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UpdateTC(context.Background(), []models.TestCase{{ID: "1", CID: "cid", AppID: "app", URI: "/b10aliens"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteTC(context.Background(), "cid", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if tele.events != 0 || tr.calls != 0 {
+		t.Fatal("THIS IS EXP", 0, 0, " \n THIS IS ACT", tele.events, tr.calls)
+	}
+}
+
+func TestSortBodyKeys(t *testing.T) {
+	for _, tt := range []struct {
+		sort     bool
+		exp      string
+		actual   string
+		pass     bool
+		expected string
+		got      string
+	}{
+		// keys are sorted recursively when the option is enabled
+		{
+			sort:     true,
+			exp:      `{"name": "Alien-X", "power": 90000, "meta": {"z": 1, "a": [{"y": true, "b": null}]}}`,
+			actual:   `{"power": 90001, "meta": {"a": [{"b": null, "y": true}], "z": 1}, "name": "Alien-X"}`,
+			pass:     false,
+			expected: `{"meta":{"a":[{"b":null,"y":true}],"z":1},"name":"Alien-X","power":90000}`,
+			got:      `{"meta":{"a":[{"b":null,"y":true}],"z":1},"name":"Alien-X","power":90001}`,
+		},
+		// html characters and large numbers are preserved as it is
+		{
+			sort:     true,
+			exp:      `{"b": "<a>&", "a": 12345678901234567890}`,
+			actual:   `{"a": 12345678901234567890, "b": "<a>&"}`,
+			pass:     true,
+			expected: `{"a":12345678901234567890,"b":"<a>&"}`,
+			got:      `{"a":12345678901234567890,"b":"<a>&"}`,
+		},
+		// bodies are stored in capture order when the option is disabled
+		{
+			sort:     false,
+			exp:      `{"b": 1, "a": 2}`,
+			actual:   `{"a": 2, "b": 1}`,
+			pass:     true,
+			expected: `{"b": 1, "a": 2}`,
+			got:      `{"a": 2, "b": 1}`,
+		},
+	} {
+		r := newTestRegression(models.TestCase{
+			ID:       "1",
+			CID:      "cid",
+			HttpResp: models.HttpResp{StatusCode: 200, Body: tt.exp},
+		})
+		r.SortBodyKeys = tt.sort
+		pass, res, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: 200, Body: tt.actual})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != tt.pass || res.BodyResult.Normal != tt.pass {
+			t.Fatal("THIS IS EXP", tt.pass, " \n THIS IS ACT", pass)
+		}
+		if res.BodyResult.Expected != tt.expected || res.BodyResult.Actual != tt.got {
+			t.Fatal("THIS IS EXP", tt.expected, tt.got, " \n THIS IS ACT", res.BodyResult.Expected, res.BodyResult.Actual)
+		}
+	}
+}
+
+func TestFlattenFloats(t *testing.T) {
+	for _, tt := range []struct {
+		body string
+		exp  string
+	}{
+		// integers are decoded as floats
+		{body: `{"power": 90000}`, exp: "90000"},
+		{body: `{"power": 0}`, exp: "0"},
+		{body: `{"power": -50}`, exp: "-50"},
+		// decimals
+		{body: `{"power": 1.5}`, exp: "1.5"},
+		{body: `{"power": 0.001}`, exp: "0.001"},
+		// large values
+		{body: `{"power": 12345678901234}`, exp: "12345678901234"},
+		{body: `{"power": 1e21}`, exp: "1000000000000000000000"},
+	} {
+		var j interface{}
+		if err := json.Unmarshal([]byte(tt.body), &j); err != nil {
+			t.Fatal(err)
+		}
+		act := flatten(j)["power"]
+		if len(act) != 1 || act[0] != tt.exp {
+			t.Fatal("THIS IS EXP", tt.exp, " \n THIS IS ACT", act)
+		}
+	}
+}
+
+func TestDeDupFloats(t *testing.T) {
+	// the anchors of the stored testcase hold the numbers as they are written in the request
+	r := newTestRegression(models.TestCase{
+		ID:      "1",
+		CID:     "cid",
+		AppID:   "app",
+		URI:     "/b10aliens",
+		Anchors: map[string][]string{"body.power": {"90000"}, "body.ratio": {"0.25"}},
+		AllKeys: map[string][]string{"body.power": {"90000"}, "body.ratio": {"0.25"}},
+	})
+	for _, tt := range []struct {
+		body string
+		dup  bool
+	}{
+		{body: `{"power": 90000, "ratio": 0.25}`, dup: true},
+		{body: `{"power": 90000.0, "ratio": 0.250}`, dup: true},
+		{body: `{"power": 90001, "ratio": 0.25}`, dup: false},
+	} {
+		tc := models.TestCase{CID: "cid", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: tt.body}}
+		dup, err := r.isDup(context.Background(), &tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dup != tt.dup {
+			t.Fatal("THIS IS EXP", tt.dup, " \n THIS IS ACT", dup, tt.body)
+		}
+	}
+}
+
+func TestDedupIndexMethod(t *testing.T) {
+	r := newTestRegression()
+	for _, tt := range []struct {
+		method models.Method
+		dup    bool
+	}{
+		{method: models.MethodGet, dup: false},
+		// the same request with another method isn't a duplicate
+		{method: models.MethodPost, dup: false},
+		{method: models.MethodGet, dup: true},
+		{method: models.MethodPost, dup: true},
+	} {
+		tc := models.TestCase{CID: "cid", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Method: tt.method, Body: `{"name": "Xlr8"}`}}
+		dup, err := r.isDup(context.Background(), &tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dup != tt.dup {
+			t.Fatal("THIS IS EXP", tt.dup, " \n THIS IS ACT", dup, tt.method)
+		}
+	}
+	// every method has its own caches
+	for _, index := range []string{"cid-app-GET-/b10aliens", "cid-app-POST-/b10aliens"} {
+		if len(r.anchors[index]) != 2 || r.fieldCounts[index]["body.name"]["Xlr8"] != 2 {
+			t.Fatal("THIS IS EXP", 2, " \n THIS IS ACT", r.anchors[index], r.fieldCounts[index], index)
+		}
+	}
+}
+
+func TestDedupRepeatedParams(t *testing.T) {
+	r := newTestRegression()
+	for _, tt := range []struct {
+		url string
+		dup bool
+	}{
+		{url: "http://localhost:8080/b10aliens?a=1&a=2", dup: false},
+		// the same values in another order
+		{url: "http://localhost:8080/b10aliens?a=2&a=1", dup: true},
+		// only the first value is the same
+		{url: "http://localhost:8080/b10aliens?a=1", dup: false},
+		{url: "http://localhost:8080/b10aliens?a=1&a=3", dup: false},
+		{url: "http://localhost:8080/b10aliens?a=3&a=1", dup: true},
+	} {
+		u, _ := url.Parse(tt.url)
+		// URLParams only holds the first value of a param
+		tc := models.TestCase{CID: "cid", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{
+			URL: tt.url, URLParams: map[string]string{"a": u.Query().Get("a")},
+		}}
+		dup, err := r.isDup(context.Background(), &tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dup != tt.dup {
+			t.Fatal("THIS IS EXP", tt.dup, " \n THIS IS ACT", dup, tt.url)
+		}
+	}
+}
+
+func TestDeNoiseRequest(t *testing.T) {
+	keys := map[string][]string{"body.name": {"Xlr8"}, "body.ts": {"1"}}
+	r := newTestRegression(models.TestCase{
+		ID:      "1",
+		CID:     "cid",
+		AppID:   "app",
+		URI:     "/b10aliens",
+		HttpReq: models.HttpReq{Body: `{"name":"Xlr8","ts":1}`},
+		Anchors: keys,
+		AllKeys: keys,
+	})
+	isDup := func(body string) bool {
+		tc := models.TestCase{CID: "cid", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: body}}
+		dup, err := r.isDup(context.Background(), &tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dup
+	}
+	if isDup(`{"name":"Xlr8","ts":2}`) {
+		t.Fatal("THIS IS EXP", false, " \n THIS IS ACT", true)
+	}
+
+	err := r.DeNoiseRequest(context.Background(), "cid", "1", "app", models.HttpReq{Body: `{"name":"Xlr8","ts":2}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc, _ := r.tdb.Get(context.Background(), "cid", "1")
+	if !reflect.DeepEqual(tc.Noise, []string{"req.body.ts"}) {
+		t.Fatal("THIS IS EXP", []string{"req.body.ts"}, " \n THIS IS ACT", tc.Noise)
+	}
+	// the timestamp isn't an anchor anymore
+	if !isDup(`{"name":"Xlr8","ts":3}`) {
+		t.Fatal("THIS IS EXP", true, " \n THIS IS ACT", false)
+	}
+	if isDup(`{"name":"Alien-X","ts":3}`) {
+		t.Fatal("THIS IS EXP", false, " \n THIS IS ACT", true)
+	}
+}
+
+func TestMaxDedupBodySize(t *testing.T) {
+	r := newTestRegression()
+	r.MaxDedupBodySize = 20
+	small := models.HttpReq{Body: `{"name":"Xlr8"}`}
+	keys, err := r.requestKeys(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, map[string][]string{"body.name": {"Xlr8"}}) {
+		t.Fatal("THIS IS EXP", map[string][]string{"body.name": {"Xlr8"}}, " \n THIS IS ACT", keys)
+	}
+
+	// the large bodies are only compared as a whole
+	large := models.HttpReq{Body: `{"name":"Xlr8","power":"speed"}`}
+	keys, _ = r.requestKeys(large)
+	if len(keys) != 1 || len(keys["body"]) != 1 || len(keys["body"][0]) != 64 {
+		t.Fatal("THIS IS EXP", "a body hash", " \n THIS IS ACT", keys)
+	}
+	other, _ := r.requestKeys(models.HttpReq{Body: `{"name":"Xlr8","power":"fire!"}`})
+	same, _ := r.requestKeys(large)
+	if reflect.DeepEqual(keys, other) || !reflect.DeepEqual(keys, same) {
+		t.Fatal("THIS IS EXP", keys, " \n THIS IS ACT", other, same)
+	}
+}
+
+func TestMaxJSONDepth(t *testing.T) {
+	r := newTestRegression()
+	r.MaxJSONDepth = 3
+	if _, err := r.requestKeys(models.HttpReq{Body: `{"a":{"b":{"c":1}}}`}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := r.requestKeys(models.HttpReq{Body: `{"a":{"b":{"c":{"d":1}}}}`})
+	if !errors.Is(err, pkg.ErrMaxDepth) {
+		t.Fatal("THIS IS EXP", pkg.ErrMaxDepth, " \n THIS IS ACT", err)
+	}
+}
+
+func TestFormBody(t *testing.T) {
+	form := http.Header{"Content-Type": {"application/x-www-form-urlencoded; charset=utf-8"}}
+	r := newTestRegression(models.TestCase{
+		ID:      "1",
+		CID:     "cid",
+		AppID:   "app",
+		URI:     "/b10aliens",
+		Anchors: map[string][]string{"header.Content-Type": {form.Get("Content-Type")}, "body.name": {"Xlr8"}, "body.tags": {"a", "b"}},
+		AllKeys: map[string][]string{"header.Content-Type": {form.Get("Content-Type")}, "body.name": {"Xlr8"}, "body.tags": {"a", "b"}},
+	})
+	for _, tt := range []struct {
+		body string
+		dup  bool
+	}{
+		{body: "name=Xlr8&tags=a&tags=b", dup: true},
+		{body: "tags=b&name=Xlr8&tags=a", dup: true},
+		{body: "name=Alien-X&tags=a&tags=b", dup: false},
+		{body: "name=Xlr8&tags=a", dup: false},
+	} {
+		tc := models.TestCase{CID: "cid", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Header: form, Body: tt.body}}
+		dup, err := r.isDup(context.Background(), &tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dup != tt.dup {
+			t.Fatal("THIS IS EXP", tt.dup, " \n THIS IS ACT", dup, tt.body)
+		}
+	}
+
+	for _, tt := range []struct {
+		exp    string
+		actual string
+		noise  []string
+		pass   bool
+	}{
+		{exp: "name=Xlr8&power=9000", actual: "power=9000&name=Xlr8", pass: true},
+		{exp: "name=Xlr8&ts=1", actual: "name=Xlr8&ts=2", noise: []string{"body.ts"}, pass: true},
+		{exp: "name=Xlr8&ts=1", actual: "name=Xlr8&ts=2", pass: false},
+		{exp: "name=Xlr8", actual: "name=Xlr8&power=9000", pass: false},
+	} {
+		r := newTestRegression(models.TestCase{
+			ID:       "1",
+			CID:      "cid",
+			HttpResp: models.HttpResp{StatusCode: 200, Header: form, Body: tt.exp},
+			Noise:    tt.noise,
+		})
+		pass, _, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: 200, Header: form, Body: tt.actual})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != tt.pass {
+			t.Fatal("THIS IS EXP", tt.pass, " \n THIS IS ACT", pass, tt.exp, tt.actual)
+		}
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for _, tt := range []struct {
+		class  bool
+		actual int
+		pass   bool
+	}{
+		{class: false, actual: 200, pass: true},
+		{class: false, actual: 201, pass: false},
+		{class: true, actual: 201, pass: true},
+		{class: true, actual: 299, pass: true},
+		{class: true, actual: 404, pass: false},
+	} {
+		r := newTestRegression(models.TestCase{ID: "1", CID: "cid", HttpResp: models.HttpResp{StatusCode: 200}, StatusClass: tt.class})
+		pass, res, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: tt.actual})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != tt.pass || res.StatusCode.Normal != tt.pass || res.StatusCode.Class != tt.class {
+			t.Fatal("THIS IS EXP", tt.pass, " \n THIS IS ACT", pass, res.StatusCode)
+		}
+	}
+}
+
+func TestMaxLatency(t *testing.T) {
+	for _, tt := range []struct {
+		max     int64
+		latency int64
+		pass    bool
+		res     *run.IntResult
+	}{
+		// without a threshold the latency is only recorded
+		{max: 0, latency: 0, pass: true},
+		{max: 0, latency: 500, pass: true, res: &run.IntResult{Normal: true, Expected: 0, Actual: 500}},
+		// a threshold can't be asserted without a reported latency
+		{max: 100, latency: 0, pass: false, res: &run.IntResult{Normal: false, Expected: 100, Actual: 0}},
+		{max: 100, latency: 100, pass: true, res: &run.IntResult{Normal: true, Expected: 100, Actual: 100}},
+		{max: 100, latency: 101, pass: false, res: &run.IntResult{Normal: false, Expected: 100, Actual: 101}},
+	} {
+		r := newTestRegression(models.TestCase{ID: "1", CID: "cid", HttpResp: models.HttpResp{StatusCode: 200}, MaxLatency: tt.max})
+		pass, res, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: 200, Latency: tt.latency})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != tt.pass || !reflect.DeepEqual(res.Latency, tt.res) {
+			t.Fatal("THIS IS EXP", tt.pass, tt.res, " \n THIS IS ACT", pass, res.Latency)
+		}
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	r := newTestRegression(models.TestCase{ID: "1", CID: "cid"})
+	if _, err := r.Get(context.Background(), "cid", "app", "1"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := r.Get(context.Background(), "cid", "app", "2")
+	if !errors.Is(err, ErrTestCaseNotFound) {
+		t.Fatal("THIS IS EXP", ErrTestCaseNotFound, " \n THIS IS ACT", err)
+	}
+}
+
+func TestResultMethod(t *testing.T) {
+	r := newTestRegression(models.TestCase{ID: "1", CID: "cid", URI: "/b10aliens", HttpReq: models.HttpReq{Method: models.MethodPost}, HttpResp: models.HttpResp{StatusCode: 201}})
+	rdb := r.rdb.(*mockRunDB)
+	rdb.runs["run-1"] = run.TestRun{ID: "run-1", CID: "cid"}
+	if _, err := r.Test(context.Background(), "cid", "app", "run-1", "1", models.HttpResp{StatusCode: 201}); err != nil {
+		t.Fatal(err)
+	}
+	tests, _ := rdb.ReadTests(context.Background(), "run-1")
+	if len(tests) != 1 || tests[0].Method != models.MethodPost || tests[0].URI != "/b10aliens" {
+		t.Fatal("THIS IS EXP", models.MethodPost, " \n THIS IS ACT", tests)
+	}
+}
+
+func TestGetAppsSorted(t *testing.T) {
+	var tcs []models.TestCase
+	// the mock returns the apps in the random order of its map
+	for i, app := range []string{"zeta", "alpha", "mid", "beta", "omega"} {
+		tcs = append(tcs, models.TestCase{ID: strconv.Itoa(i), CID: "cid", AppID: app})
+	}
+	r := newTestRegression(tcs...)
+	apps, err := r.GetApps(context.Background(), "cid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"alpha", "beta", "mid", "omega", "zeta"}
+	if !reflect.DeepEqual(apps, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", apps)
+	}
+}
+
+func TestDeleteTC(t *testing.T) {
+	keys := map[string][]string{"body.name": {"Xlr8"}}
+	r := newTestRegression(models.TestCase{ID: "1", CID: "cid", AppID: "app", URI: "/b10aliens", Anchors: keys, AllKeys: keys})
+	// fill the cache of the index
+	tc := models.TestCase{CID: "cid", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: `{"name": "Xlr8"}`}}
+	if dup, _ := r.isDup(context.Background(), &tc); !dup {
+		t.Fatal("THIS IS EXP", true, " \n THIS IS ACT", dup)
+	}
+
+	if err := r.DeleteTC(context.Background(), "cid", "1"); err != nil {
+		t.Fatal(err)
+	}
+	// the whole cache of the index is dropped, so that it is rebuilt without the deleted testcase
+	index := dedupIndex(&tc)
+	if _, ok := r.noisyFields[index]; ok {
+		t.Fatal("THIS IS EXP", nil, " \n THIS IS ACT", r.noisyFields[index])
+	}
+	if _, ok := r.fieldCounts[index]; ok {
+		t.Fatal("THIS IS EXP", nil, " \n THIS IS ACT", r.fieldCounts[index])
+	}
+	if dup, _ := r.isDup(context.Background(), &tc); dup {
+		t.Fatal("THIS IS EXP", false, " \n THIS IS ACT", dup)
+	}
+}
+
+func TestDeleteTCs(t *testing.T) {
+	keys := map[string][]string{"body.name": {"Xlr8"}}
+	r := newTestRegression(
+		models.TestCase{ID: "1", CID: "cid", AppID: "app", URI: "/b10aliens", Anchors: keys, AllKeys: keys},
+		models.TestCase{ID: "2", CID: "cid", AppID: "app", URI: "/b10aliens", Anchors: keys, AllKeys: keys},
+		models.TestCase{ID: "3", CID: "cid", AppID: "app", URI: "/b10aliens/1"},
+	)
+	tele := &mockTelemetry{}
+	r.tele = tele
+	tdb := r.tdb.(*mockTestCaseDB)
+	tdb.deleteErrs = map[string]error{"3": errors.New("connection reset")}
+	// fill the cache of the index
+	tc := models.TestCase{CID: "cid", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: `{"name": "Xlr8"}`}}
+	if dup, _ := r.isDup(context.Background(), &tc); !dup {
+		t.Fatal("THIS IS EXP", true, " \n THIS IS ACT", dup)
+	}
+
+	res, err := r.DeleteTCs(context.Background(), "cid", []string{"1", "missing", "2", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []DeleteResult{
+		{ID: "1", Deleted: true},
+		{ID: "missing", Error: ErrTestCaseNotFound.Error()},
+		{ID: "2", Deleted: true},
+		{ID: "3", Error: "internal failure"},
+	}
+	if !reflect.DeepEqual(res, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", res)
+	}
+	if _, ok := tdb.tcs["3"]; !ok || len(tdb.tcs) != 1 {
+		t.Fatal("THIS IS EXP", []string{"3"}, " \n THIS IS ACT", tdb.tcs)
+	}
+	if tele.events != 1 {
+		t.Fatal("THIS IS EXP", 1, " \n THIS IS ACT", tele.events)
+	}
+	// the cache of the index is rebuilt without the deleted testcases
+	if dup, _ := r.isDup(context.Background(), &tc); dup {
+		t.Fatal("THIS IS EXP", false, " \n THIS IS ACT", dup)
+	}
+
+	if _, err := r.DeleteTCs(context.Background(), "cid", nil); err == nil {
+		t.Fatal("expected an error for no ids")
+	}
+}
+
+func TestMissingTestCase(t *testing.T) {
+	r := newTestRegression()
+	rdb := r.rdb.(*mockRunDB)
+	rdb.runs["run-1"] = run.TestRun{ID: "run-1", CID: "cid"}
+
+	pass, err := r.Test(context.Background(), "cid", "app", "run-1", "missing", models.HttpResp{StatusCode: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pass {
+		t.Fatal("expected a missing testcase to fail")
+	}
+	tests, _ := rdb.ReadTests(context.Background(), "run-1")
+	if len(tests) != 1 || tests[0].Status != run.TestStatusFailed || tests[0].TestCaseID != "missing" {
+		t.Fatal("unexpected test results", tests)
+	}
+	if tr := rdb.runs["run-1"]; tr.Failure != 1 || tr.Success != 0 {
+		t.Fatal("unexpected test run", tr)
+	}
+}
+
+// counts returns the field counts of unique values, each seen once, and a value seen rest times.
+func counts(unique, rest int) map[string]int {
+	m := map[string]int{}
+	for i := 0; i < unique; i++ {
+		m[strconv.Itoa(i)] = 1
+	}
+	if rest > 0 {
+		m["rest"] = rest
+	}
+	return m
+}
+
+func TestIsAnchor(t *testing.T) {
+	for _, tt := range []struct {
+		minSamples int
+		ratio      float64
+		counts     map[string]int
+		anchor     bool
+	}{
+		// default thresholds, 19 unique values are too few samples to judge
+		{minSamples: 20, ratio: 0.40, counts: counts(19, 0), anchor: true},
+		{minSamples: 20, ratio: 0.40, counts: counts(20, 0), anchor: false},
+		// 20 values, there must be fewer than 8 (40%) distinct ones
+		{minSamples: 20, ratio: 0.40, counts: counts(6, 14), anchor: true},
+		{minSamples: 20, ratio: 0.40, counts: counts(7, 13), anchor: false},
+		// a raised sample floor
+		{minSamples: 100, ratio: 0.40, counts: counts(99, 0), anchor: true},
+		{minSamples: 100, ratio: 0.40, counts: counts(100, 0), anchor: false},
+		// a loosened ratio, 20 values with fewer than 15 (75%) distinct ones
+		{minSamples: 20, ratio: 0.75, counts: counts(13, 7), anchor: true},
+		{minSamples: 20, ratio: 0.75, counts: counts(14, 6), anchor: false},
+	} {
+		r := newTestRegression()
+		r.AnchorMinSamples, r.AnchorMaxUniqueRatio = tt.minSamples, tt.ratio
+		if act := r.isAnchor(tt.counts); act != tt.anchor {
+			t.Fatal("THIS IS EXP", tt.anchor, " \n THIS IS ACT", act, tt.minSamples, tt.ratio, len(tt.counts))
+		}
+	}
+}
+
+func TestNewAnchorDefaults(t *testing.T) {
+	r := newTestRegression()
+	if r.AnchorMinSamples != 20 || r.AnchorMaxUniqueRatio != 0.40 {
+		t.Fatal("unexpected default anchor thresholds", r.AnchorMinSamples, r.AnchorMaxUniqueRatio)
+	}
+}
+
+func TestConcurrentPut(t *testing.T) {
+	r := newTestRegression()
+	r.EnableDeDup = true
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tc := models.TestCase{
+				ID:      strconv.Itoa(i),
+				AppID:   "app",
+				URI:     "/b10aliens",
+				HttpReq: models.HttpReq{Body: fmt.Sprintf(`{"name": "alien-%d", "power": %d}`, i, i%3)},
+			}
+			if _, err := r.Put(context.Background(), "cid", []models.TestCase{tc}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestPutDuplicates(t *testing.T) {
+	r := newTestRegression()
+	r.EnableDeDup = true
+	tcs := []models.TestCase{
+		{ID: "1", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: `{"name": "Xlr8"}`}},
+		{ID: "2", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: `{"name": "Xlr8"}`}},
+		{ID: "3", AppID: "app", URI: "/b10aliens/1", HttpReq: models.HttpReq{Body: `{"name": "Xlr8"}`}},
+	}
+	res, err := r.Put(context.Background(), "cid", tcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := PutResult{Inserted: []string{"1", "3"}, Duplicates: []string{"/b10aliens"}}
+	if !reflect.DeepEqual(res, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", res)
+	}
+}
+
+func TestDryRunPut(t *testing.T) {
+	r := newTestRegression()
+	r.EnableDeDup = true
+	ctx := context.Background()
+	_, err := r.Put(ctx, "cid", []models.TestCase{{ID: "1", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: `{"name": "Xlr8"}`}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, err := r.DryRunPut(ctx, "cid", []models.TestCase{
+		{ID: "2", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: `{"name": "Xlr8"}`}},
+		{ID: "3", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: `{"name": "Heatblast"}`}},
+		{ID: "4", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: `{"name": "Heatblast"}`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var act []bool
+	for _, rep := range reports {
+		if rep.URI != "/b10aliens" || len(rep.Anchors) == 0 {
+			t.Fatal("unexpected report", rep)
+		}
+		act = append(act, rep.Duplicate)
+	}
+	if exp := []bool{true, false, true}; !reflect.DeepEqual(act, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", act)
+	}
+	// nothing is saved and the caches are unchanged
+	for _, id := range []string{"2", "3", "4"} {
+		if _, err := r.tdb.Get(ctx, "cid", id); err == nil {
+			t.Fatal("testcase saved by the dry run", id)
+		}
+	}
+	if n := len(r.anchors["cid-app--/b10aliens"]); n != 1 {
+		t.Fatal("THIS IS EXP", 1, " \n THIS IS ACT", n)
+	}
+}
+
+func TestUpdateTCValidation(t *testing.T) {
+	valid := models.TestCase{ID: "1", URI: "/b10aliens", HttpReq: models.HttpReq{Method: models.MethodGet, URL: "http://localhost:8080/b10aliens"}}
+	for _, tt := range []struct {
+		name   string
+		update func(*models.TestCase)
+		err    string
+	}{
+		{name: "valid", update: func(*models.TestCase) {}},
+		{name: "no method", update: func(tc *models.TestCase) { tc.HttpReq.Method = "" }},
+		{name: "no id", update: func(tc *models.TestCase) { tc.ID = "" }, err: `invalid testcase 1 (id ""): id is required`},
+		{name: "no uri", update: func(tc *models.TestCase) { tc.URI = "" }, err: `invalid testcase 1 (id "1"): uri is required`},
+		{name: "bad method", update: func(tc *models.TestCase) { tc.HttpReq.Method = "FETCH" }, err: `invalid testcase 1 (id "1"): invalid http method "FETCH"`},
+		{name: "bad url", update: func(tc *models.TestCase) { tc.HttpReq.URL = "http://[::1" }, err: `invalid testcase 1 (id "1"): invalid url: parse "http://[::1": missing ']' in host`},
+	} {
+		r := newTestRegression(models.TestCase{ID: "0", URI: "/b10aliens"})
+		tc := valid
+		tt.update(&tc)
+		err := r.UpdateTC(context.Background(), []models.TestCase{{ID: "0", URI: "/b10aliens", HttpReq: models.HttpReq{Body: "updated"}}, tc})
+		if tt.err == "" {
+			if err != nil {
+				t.Fatal(tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Fatal("THIS IS EXP", tt.err, " \n THIS IS ACT", err, tt.name)
+		}
+		// nothing is updated when a testcase is invalid
+		if tc, _ := r.tdb.Get(context.Background(), "", "0"); tc.HttpReq.Body != "" {
+			t.Fatal("THIS IS EXP", "", " \n THIS IS ACT", tc.HttpReq.Body, tt.name)
+		}
+	}
+}
+
+func TestNoisyFields(t *testing.T) {
+	var tcs []models.TestCase
+	for i := 0; i < 5; i++ {
+		keys := map[string][]string{"body.name": {"Xlr8"}, "body.ts": {strconv.Itoa(i)}}
+		tcs = append(tcs, models.TestCase{ID: strconv.Itoa(i), CID: "cid", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Method: models.MethodPost}, AllKeys: keys})
+	}
+	r := newTestRegression(tcs...)
+	r.AnchorMinSamples = 5
+	fields, err := r.NoisyFields(context.Background(), "cid", "app", models.MethodPost, "/b10aliens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fields, []string{"body.ts"}) {
+		t.Fatal("THIS IS EXP", []string{"body.ts"}, " \n THIS IS ACT", fields)
+	}
+	fields, _ = r.NoisyFields(context.Background(), "cid", "app", models.MethodGet, "/b10aliens")
+	if len(fields) != 0 {
+		t.Fatal("THIS IS EXP", []string{}, " \n THIS IS ACT", fields)
+	}
+}
+
+func TestResetCache(t *testing.T) {
+	keys := map[string][]string{"body.name": {"Xlr8"}}
+	r := newTestRegression(
+		models.TestCase{ID: "1", CID: "cid", AppID: "app", URI: "/b10aliens", Anchors: keys, AllKeys: keys},
+		models.TestCase{ID: "2", CID: "cid", AppID: "app-2", URI: "/b10aliens", Anchors: keys, AllKeys: keys},
+	)
+	isDup := func(app string) bool {
+		tc := models.TestCase{CID: "cid", AppID: app, URI: "/b10aliens", HttpReq: models.HttpReq{Body: `{"name": "Xlr8"}`}}
+		dup, err := r.isDup(context.Background(), &tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dup
+	}
+	if !isDup("app") || !isDup("app-2") {
+		t.Fatal("THIS IS EXP", true, " \n THIS IS ACT", false)
+	}
+	// the testcases are deleted behind the cache
+	tdb := r.tdb.(*mockTestCaseDB)
+	delete(tdb.tcs, "1")
+	delete(tdb.tcs, "2")
+	if !isDup("app") {
+		t.Fatal("THIS IS EXP", true, " \n THIS IS ACT", false)
+	}
+
+	r.ResetCache("cid", "app")
+	if isDup("app") {
+		t.Fatal("THIS IS EXP", false, " \n THIS IS ACT", true)
+	}
+	// the cache of another app is kept
+	if !isDup("app-2") {
+		t.Fatal("THIS IS EXP", true, " \n THIS IS ACT", false)
+	}
+}
+
+func TestPersistedCache(t *testing.T) {
+	cdb := &mockCacheDB{caches: map[string]models.DedupCache{}}
+	r := newTestRegression()
+	r.EnableDeDup = true
+	r.CacheDB = cdb
+	tc := models.TestCase{ID: "1", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: `{"name": "Xlr8"}`}}
+	if _, err := r.Put(context.Background(), "cid", []models.TestCase{tc}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cdb.caches["cid-app--/b10aliens"]; !ok {
+		t.Fatal("THIS IS EXP", "cid-app--/b10aliens", " \n THIS IS ACT", cdb.caches)
+	}
+
+	// after a restart the cache is read from the DB instead of the testcases
+	r = newTestRegression()
+	r.EnableDeDup = true
+	r.CacheDB = cdb
+	tc.ID = "2"
+	res, err := r.Put(context.Background(), "cid", []models.TestCase{tc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Duplicates) != 1 {
+		t.Fatal("THIS IS EXP", 1, " \n THIS IS ACT", res)
+	}
+	c := cdb.caches["cid-app--/b10aliens"]
+	if !reflect.DeepEqual(c.FieldCounts, []models.FieldCount{{Key: "body.name", Value: "Xlr8", Count: 2}}) {
+		t.Fatal("THIS IS EXP", 2, " \n THIS IS ACT", c.FieldCounts)
+	}
+
+	r.ResetCache("cid", "app")
+	if len(cdb.caches) != 0 {
+		t.Fatal("THIS IS EXP", 0, " \n THIS IS ACT", cdb.caches)
+	}
+}
+
+func TestXMLBody(t *testing.T) {
+	for _, tt := range []struct {
+		exp    string
+		actual string
+		noise  []string
+		pass   bool
+	}{
+		{
+			exp:    `<aliens><alien id="1" name="Alien-X"/></aliens>`,
+			actual: "<aliens>\n  <alien name=\"Alien-X\" id=\"1\"/>\n</aliens>",
+			pass:   true,
+		},
+		{
+			exp:    `<alien id="1" updated="100"/>`,
+			actual: `<alien updated="200" id="1"/>`,
+			noise:  []string{"body.alien.@updated"},
+			pass:   true,
+		},
+		{
+			exp:    `<alien id="1" updated="100"/>`,
+			actual: `<alien updated="200" id="1"/>`,
+			pass:   false,
+		},
+	} {
+		r := newTestRegression(models.TestCase{
+			ID:       "1",
+			CID:      "cid",
+			HttpResp: models.HttpResp{StatusCode: 200, Body: tt.exp},
+			Noise:    tt.noise,
+		})
+		pass, res, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: 200, Body: tt.actual})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.BodyResult.Type != run.BodyTypeXML {
+			t.Fatal("THIS IS EXP", run.BodyTypeXML, " \n THIS IS ACT", res.BodyResult.Type)
+		}
+		if pass != tt.pass {
+			t.Fatal(tt.exp, tt.actual, "THIS IS EXP", tt.pass, " \n THIS IS ACT", pass)
+		}
+	}
+}
+
+func TestRegexNoise(t *testing.T) {
+	for _, tt := range []struct {
+		noise  []string
+		header http.Header
+		pass   bool
+		err    bool
+	}{
+		// the ids of every item and of the meta are ignored
+		{noise: []string{`re:body\..*\.id`, "header.Date"}, pass: true},
+		// the pattern has to match the whole key
+		{noise: []string{`re:id`, "header.Date"}, pass: false},
+		{noise: []string{`re:body\.items\..*`, `re:header\.(Date|X-.*)`}, header: http.Header{"X-Request-Id": {"2"}}, pass: false},
+		{noise: []string{`re:body\..*\.id`, `re:header\.(Date|X-.*)`}, header: http.Header{"X-Request-Id": {"2"}}, pass: true},
+		{noise: []string{`re:body.(`}, err: true},
+	} {
+		r := newTestRegression(models.TestCase{
+			ID:  "1",
+			CID: "cid",
+			HttpResp: models.HttpResp{
+				StatusCode: 200,
+				Header:     http.Header{"Date": {"Mon"}, "X-Request-Id": {"1"}},
+				Body:       `{"items": [{"id": 1, "name": "Xlr8"}, {"id": 2, "name": "Alien-X"}], "meta": {"id": 3}}`,
+			},
+			Noise: tt.noise,
+		})
+		h := http.Header{"Date": {"Tue"}, "X-Request-Id": {"1"}}
+		for k, v := range tt.header {
+			h[k] = v
+		}
+		pass, _, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{
+			StatusCode: 200,
+			Header:     h,
+			Body:       `{"items": [{"id": 4, "name": "Xlr8"}, {"id": 5, "name": "Alien-X"}], "meta": {"id": 6}}`,
+		})
+		if (err != nil) != tt.err {
+			t.Fatal("THIS IS EXP", tt.err, " \n THIS IS ACT", err, tt.noise)
+		}
+		if pass != tt.pass {
+			t.Fatal("THIS IS EXP", tt.pass, " \n THIS IS ACT", pass, tt.noise)
+		}
+	}
+}
+
+func TestDeNoiseRegex(t *testing.T) {
+	r := newTestRegression(models.TestCase{
+		ID:       "1",
+		CID:      "cid",
+		HttpResp: models.HttpResp{StatusCode: 200, Body: `{"id": 1, "meta": {"created": 10, "updated": 10}, "name": "Xlr8"}`},
+		Noise:    []string{`re:body\.meta\..*`},
+	})
+	err := r.DeNoise(context.Background(), "cid", "1", "app", `{"id": 2, "meta": {"created": 20, "updated": 20}, "name": "Xlr8"}`, http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc, _ := r.tdb.Get(context.Background(), "cid", "1")
+	exp := []string{`re:body\.meta\..*`, "body.id"}
+	if !reflect.DeepEqual(tc.Noise, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", tc.Noise)
+	}
+
+	r = newTestRegression(models.TestCase{ID: "1", CID: "cid", HttpResp: models.HttpResp{Body: `{}`}, Noise: []string{`re:[`}})
+	if err := r.DeNoise(context.Background(), "cid", "1", "app", `{}`, http.Header{}); err == nil {
+		t.Fatal("expected an error for an invalid noise regex")
+	}
+}
+
+func TestGlobNoise(t *testing.T) {
+	// flatten merges the elements of the nested arrays, eg: body.groups.items.ts holds all the ts values
+	exp := `{"ts": 5, "groups": [{"name": "a", "items": [{"id": 1, "ts": 10}, {"id": 2, "ts": 20}]}, {"name": "b", "items": []}]}`
+	actual := `{"ts": 6, "groups": [{"name": "a", "items": [{"id": 1, "ts": 11}, {"id": 2, "ts": 21}]}, {"name": "b", "items": []}]}`
+	for _, tt := range []struct {
+		noise []string
+		pass  bool
+		err   bool
+	}{
+		{noise: []string{"body.*.items.ts", "body.ts"}, pass: true},
+		{noise: []string{"body.*.*.ts", "body.ts"}, pass: true},
+		{noise: []string{"body.groups.*.t*", "body.t*"}, pass: true},
+		// a "*" matches a single segment
+		{noise: []string{"body.*.ts", "body.ts"}, pass: false},
+		{noise: []string{"body.*"}, pass: false},
+		// the ids don't change, so ignoring them doesn't help
+		{noise: []string{"body.groups.items.*"}, pass: false},
+		{noise: []string{"body.groups.items.*", "body.ts"}, pass: true},
+		{noise: []string{"body.[*.ts", "body.ts"}, err: true},
+	} {
+		r := newTestRegression(models.TestCase{
+			ID:       "1",
+			CID:      "cid",
+			HttpResp: models.HttpResp{StatusCode: 200, Body: exp},
+			Noise:    tt.noise,
+		})
+		pass, _, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: 200, Body: actual})
+		if (err != nil) != tt.err {
+			t.Fatal("THIS IS EXP", tt.err, " \n THIS IS ACT", err, tt.noise)
+		}
+		if pass != tt.pass {
+			t.Fatal("THIS IS EXP", tt.pass, " \n THIS IS ACT", pass, tt.noise)
+		}
+	}
+}
+
+func TestExpandNoise(t *testing.T) {
+	keys := map[string][]string{"body.items.id": {"1"}, "body.items.ts": {"10"}, "header.Date": {"Mon"}}
+	// a key matched by an exact and a wildcard entry is only listed once
+	noise, err := newTestRegression().expandNoise([]string{"body.items.ts", "body.items.*", `re:header\..*`, "body"}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(noise)
+	exp := []string{"body", "body.items.id", "body.items.ts", "header.Date"}
+	if !reflect.DeepEqual(noise, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", noise)
+	}
+}
+
+func TestFlattenIndexed(t *testing.T) {
+	var j interface{}
+	if err := json.Unmarshal([]byte(`{"items": [{"name": "a", "tags": ["x", "y"]}, {"name": "b"}], "ids": [], "top": [1, 2]}`), &j); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string][]string{
+		"items.0.name":   {"a"},
+		"items.0.tags.0": {"x"},
+		"items.0.tags.1": {"y"},
+		"items.1.name":   {"b"},
+		"top.0":          {"1"},
+		"top.1":          {"2"},
+	}
+	if act := flattenKeys(j, true); !reflect.DeepEqual(act, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", act)
+	}
+	// the values of the elements are merged without the option
+	exp = map[string][]string{"items.name": {"a", "b"}, "items.tags": {"x", "y"}, "top": {"1", "2"}}
+	if act := flatten(j); !reflect.DeepEqual(act, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", act)
+	}
+}
+
+func TestFlattenNull(t *testing.T) {
+	var null, empty interface{}
+	json.Unmarshal([]byte(`{"x": null}`), &null)
+	json.Unmarshal([]byte(`{"x": ""}`), &empty)
+	n, e := flatten(null), flatten(empty)
+	if reflect.DeepEqual(n, e) {
+		t.Fatal("THIS IS EXP", "different values", " \n THIS IS ACT", n, e)
+	}
+	if !reflect.DeepEqual(e, map[string][]string{"x": {""}}) {
+		t.Fatal("THIS IS EXP", map[string][]string{"x": {""}}, " \n THIS IS ACT", e)
+	}
+	// the nulls are written like in JSON in the diffs
+	diffs := bodyDiffs(map[string][]string{"body.x": n["x"]}, map[string][]string{"body.x": e["x"]}, nil, false)
+	exp := []run.BodyDiff{{Key: "body.x", Expected: []string{"null"}, Actual: []string{""}}}
+	if !reflect.DeepEqual(diffs, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", diffs)
+	}
+}
+
+func TestIndexArrays(t *testing.T) {
+	for _, tt := range []struct {
+		indexed bool
+		dup     bool
+	}{
+		// reordered arrays are duplicates when the elements are merged
+		{indexed: false, dup: true},
+		{indexed: true, dup: false},
+	} {
+		r := newTestRegression()
+		r.IndexArrays = tt.indexed
+		for i, body := range []string{`{"names": ["a", "b"]}`, `{"names": ["b", "a"]}`} {
+			tc := models.TestCase{CID: "cid", AppID: "app", URI: "/b10aliens", HttpReq: models.HttpReq{Body: body}}
+			dup, err := r.isDup(context.Background(), &tc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == 1 && dup != tt.dup {
+				t.Fatal("THIS IS EXP", tt.dup, " \n THIS IS ACT", dup, tt.indexed)
+			}
+		}
+	}
+
+	// the denoised fields target the changed element only
+	r := newTestRegression(models.TestCase{
+		ID:       "1",
+		CID:      "cid",
+		HttpResp: models.HttpResp{StatusCode: 200, Body: `{"items": [{"id": 1, "ts": 10}, {"id": 2, "ts": 20}]}`},
+	})
+	r.IndexArrays = true
+	err := r.DeNoise(context.Background(), "cid", "1", "app", `{"items": [{"id": 1, "ts": 10}, {"id": 2, "ts": 21}]}`, http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc, _ := r.tdb.Get(context.Background(), "cid", "1")
+	if exp := []string{"body.items.1.ts"}; !reflect.DeepEqual(tc.Noise, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", tc.Noise)
+	}
+	for _, tt := range []struct {
+		body string
+		pass bool
+	}{
+		{body: `{"items": [{"id": 1, "ts": 10}, {"id": 2, "ts": 22}]}`, pass: true},
+		{body: `{"items": [{"id": 1, "ts": 11}, {"id": 2, "ts": 22}]}`, pass: false},
+	} {
+		pass, _, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: 200, Body: tt.body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != tt.pass {
+			t.Fatal("THIS IS EXP", tt.pass, " \n THIS IS ACT", pass, tt.body)
+		}
+	}
+}
+
+func TestUnorderedArrays(t *testing.T) {
+	for _, tt := range []struct {
+		actual string
+		pass   bool
+	}{
+		{actual: `{"tags": ["b", "c", "a", "a"]}`, pass: true},
+		// the elements differ, not just their order
+		{actual: `{"tags": ["b", "c", "c", "a"]}`, pass: false},
+	} {
+		r := newTestRegression(models.TestCase{
+			ID:              "1",
+			CID:             "cid",
+			HttpResp:        models.HttpResp{StatusCode: 200, Body: `{"tags": ["a", "a", "b", "c"]}`},
+			UnorderedArrays: true,
+		})
+		pass, _, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: 200, Body: tt.actual})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != tt.pass {
+			t.Fatal("THIS IS EXP", tt.pass, " \n THIS IS ACT", pass, tt.actual)
+		}
+	}
+}
+
+func TestFloatTolerance(t *testing.T) {
+	for _, tt := range []struct {
+		abs    float64
+		rel    float64
+		actual string
+		pass   bool
+	}{
+		{actual: `{"score": 0.1000001}`, pass: false},
+		{abs: 1e-6, actual: `{"score": 0.1000001}`, pass: true},
+		{abs: 1e-6, actual: `{"score": 0.1000011}`, pass: false},
+		{rel: 0.1, actual: `{"score": 0.11}`, pass: true},
+		{rel: 0.1, actual: `{"score": 0.12}`, pass: false},
+	} {
+		r := newTestRegression(models.TestCase{
+			ID:       "1",
+			CID:      "cid",
+			HttpResp: models.HttpResp{StatusCode: 200, Body: `{"score": 0.1}`},
+		})
+		r.FloatAbsTolerance, r.FloatRelTolerance = tt.abs, tt.rel
+		pass, _, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: 200, Body: tt.actual})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != tt.pass {
+			t.Fatal("THIS IS EXP", tt.pass, " \n THIS IS ACT", pass, tt.abs, tt.rel, tt.actual)
+		}
+	}
+}
+
+func TestIgnoredHeaders(t *testing.T) {
+	tc := models.TestCase{
+		ID:  "1",
+		CID: "cid",
+		HttpResp: models.HttpResp{
+			StatusCode: 200,
+			Header:     http.Header{"Date": {"Mon, 10 Oct 2022 10:00:00 GMT"}, "Content-Type": {"application/json"}},
+			Body:       `{"name": "Xlr8"}`,
+		},
+	}
+	resp := models.HttpResp{
+		StatusCode: 200,
+		Header:     http.Header{"Date": {"Tue, 11 Oct 2022 10:00:00 GMT"}, "Content-Type": {"application/json"}},
+		Body:       `{"name": "Xlr8"}`,
+	}
+	for _, tt := range []struct {
+		ignored []string
+		pass    bool
+	}{
+		// the default set skips the Date header
+		{ignored: pkg.DefaultIgnoredHeaders, pass: true},
+		// the headers are asserted once they are removed from the set
+		{ignored: nil, pass: false},
+		{ignored: []string{"Content-Length"}, pass: false},
+	} {
+		r := newTestRegression(tc)
+		if !reflect.DeepEqual(r.IgnoredHeaders, pkg.DefaultIgnoredHeaders) {
+			t.Fatal("THIS IS EXP", pkg.DefaultIgnoredHeaders, " \n THIS IS ACT", r.IgnoredHeaders)
+		}
+		r.IgnoredHeaders = tt.ignored
+		pass, _, _, err := r.test(context.Background(), "cid", "1", "app", resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != tt.pass {
+			t.Fatal("THIS IS EXP", tt.pass, " \n THIS IS ACT", pass, tt.ignored)
+		}
+	}
+}
+
+func TestBodyDiffs(t *testing.T) {
+	for _, tt := range []struct {
+		actual string
+		noise  []string
+		diffs  []run.BodyDiff
+	}{
+		{
+			actual: `{"id": 2, "name": "Xlr8", "meta": {"ts": 2}, "tags": ["b", "a"], "extra": true}`,
+			noise:  []string{"body.meta"},
+			diffs: []run.BodyDiff{
+				{Key: "body.extra", Actual: []string{"true"}},
+				{Key: "body.id", Expected: []string{"1"}, Actual: []string{"2"}},
+			},
+		},
+		{
+			actual: `{"id": 1, "meta": {"ts": 2}, "tags": ["a", "c"]}`,
+			noise:  []string{`re:body\.meta\..*`},
+			diffs: []run.BodyDiff{
+				{Key: "body.name", Expected: []string{"Xlr8"}},
+				{Key: "body.tags", Expected: []string{"a", "b"}, Actual: []string{"a", "c"}},
+			},
+		},
+		// the body matches
+		{actual: `{"id": 2, "name": "Xlr8", "meta": {"ts": 1}, "tags": ["a", "b"]}`, noise: []string{"body.id"}},
+	} {
+		r := newTestRegression(models.TestCase{
+			ID:       "1",
+			CID:      "cid",
+			HttpResp: models.HttpResp{StatusCode: 200, Body: `{"id": 1, "name": "Xlr8", "meta": {"ts": 1}, "tags": ["a", "b"]}`},
+			Noise:    tt.noise,
+		})
+		pass, res, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: 200, Body: tt.actual})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != (tt.diffs == nil) {
+			t.Fatal("THIS IS EXP", tt.diffs == nil, " \n THIS IS ACT", pass, tt.actual)
+		}
+		if !reflect.DeepEqual(res.BodyResult.Diffs, tt.diffs) {
+			t.Fatal("THIS IS EXP", tt.diffs, " \n THIS IS ACT", res.BodyResult.Diffs)
+		}
+	}
+}
+
+func TestPartialMatch(t *testing.T) {
+	for _, tt := range []struct {
+		partial   bool
+		unordered bool
+		expected  string
+		actual    string
+		pass      bool
+		diffs     []run.BodyDiff
+	}{
+		{partial: true, actual: `{"name": "Xlr8", "power": 9000, "id": "42"}`, pass: true},
+		// the first expected element matches both actual ones, it has to leave the second for the other
+		{partial: true, unordered: true, expected: `[{"a": 1}, {"a": 1, "b": 2}]`, actual: `[{"a": 1, "b": 2}, {"a": 1}]`, pass: true},
+		{partial: true, unordered: true, expected: `[{"a": 1}, {"a": 1, "b": 2}]`, actual: `[{"a": 1, "b": 2}, {"a": 2}]`, pass: false, diffs: []run.BodyDiff{
+			{Key: "body.a", Expected: []string{"1", "1"}, Actual: []string{"1", "2"}},
+		}},
+		{partial: false, actual: `{"name": "Xlr8", "power": 9000, "id": "42"}`, pass: false, diffs: []run.BodyDiff{
+			{Key: "body.id", Actual: []string{"42"}},
+		}},
+		// the extra fields aren't listed in the diff of a partial match
+		{partial: true, actual: `{"name": "Xlr8", "power": 9001, "id": "42"}`, pass: false, diffs: []run.BodyDiff{
+			{Key: "body.power", Expected: []string{"9000"}, Actual: []string{"9001"}},
+		}},
+	} {
+		if tt.expected == "" {
+			tt.expected = `{"name": "Xlr8", "power": 9000}`
+		}
+		r := newTestRegression(models.TestCase{
+			ID:              "1",
+			CID:             "cid",
+			HttpResp:        models.HttpResp{StatusCode: 200, Body: tt.expected},
+			PartialMatch:    tt.partial,
+			UnorderedArrays: tt.unordered,
+		})
+		pass, res, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: 200, Body: tt.actual})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != tt.pass {
+			t.Fatal("THIS IS EXP", tt.pass, " \n THIS IS ACT", pass, tt.partial, tt.actual)
+		}
+		if !reflect.DeepEqual(res.BodyResult.Diffs, tt.diffs) {
+			t.Fatal("THIS IS EXP", tt.diffs, " \n THIS IS ACT", res.BodyResult.Diffs)
+		}
+	}
+}
+
+func TestDeNoiseSamples(t *testing.T) {
+	r := newTestRegression(models.TestCase{
+		ID:       "1",
+		CID:      "cid",
+		HttpResp: models.HttpResp{StatusCode: 200, Header: http.Header{"Date": {"Mon"}}, Body: `{"id": 1, "ts": 1, "name": "Xlr8", "v": 1}`},
+		Noise:    []string{"body.old"},
+	})
+	// v only varies in the second sample, and extra is missing in the stored response
+	err := r.DeNoiseSamples(context.Background(), "cid", "1", "app", []models.HttpResp{
+		{StatusCode: 200, Header: http.Header{"Date": {"Tue"}}, Body: `{"id": 1, "ts": 2, "name": "Xlr8", "v": 1}`},
+		{StatusCode: 200, Header: http.Header{"Date": {"Tue"}}, Body: `{"id": 1, "ts": 3, "name": "Xlr8", "v": 2, "extra": true}`},
+		{StatusCode: 200, Header: http.Header{"Date": {"Wed"}}, Body: `{"id": 1, "ts": 4, "name": "Xlr8", "v": 1, "extra": true}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc, _ := r.tdb.Get(context.Background(), "cid", "1")
+	exp := []string{"body.old", "body.extra", "body.ts", "body.v", "header.Date"}
+	if !reflect.DeepEqual(tc.Noise, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", tc.Noise)
+	}
+
+	if err := r.DeNoiseSamples(context.Background(), "cid", "1", "app", nil); err == nil {
+		t.Fatal("expected an error without samples")
+	}
+}
+
+func TestDeNoiseMerge(t *testing.T) {
+	r := newTestRegression(models.TestCase{
+		ID:       "1",
+		CID:      "cid",
+		HttpResp: models.HttpResp{StatusCode: 200, Body: `{"id": 1, "ts": 1, "name": "Xlr8"}`},
+	})
+	// the first sample only changes ts, the second one only changes id
+	for _, body := range []string{`{"id": 1, "ts": 2, "name": "Xlr8"}`, `{"id": 2, "ts": 1, "name": "Xlr8"}`, `{"id": 2, "ts": 2, "name": "Xlr8"}`} {
+		if err := r.DeNoise(context.Background(), "cid", "1", "app", body, http.Header{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tc, _ := r.tdb.Get(context.Background(), "cid", "1")
+	exp := []string{"body.ts", "body.id"}
+	if !reflect.DeepEqual(tc.Noise, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", tc.Noise)
+	}
+}
+
+// gzipped returns the gzip compressed body.
+func gzipped(t *testing.T, body string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestGzipBody(t *testing.T) {
+	gz := http.Header{"Content-Encoding": {"gzip"}}
+	for _, tt := range []struct {
+		exp    string
+		actual string
+		header http.Header
+		noise  []string
+		pass   bool
+		typ    run.BodyType
+	}{
+		// the bodies are compared as JSON once decompressed, so the order of the keys doesn't matter
+		{exp: gzipped(t, `{"name": "Xlr8", "power": 9000}`), actual: gzipped(t, `{"power": 9000, "name": "Xlr8"}`), header: gz, pass: true, typ: run.BodyTypeJSON},
+		{exp: gzipped(t, `{"name": "Xlr8", "ts": 1}`), actual: gzipped(t, `{"ts": 2, "name": "Xlr8"}`), header: gz, noise: []string{"body.ts"}, pass: true, typ: run.BodyTypeJSON},
+		{exp: gzipped(t, `{"name": "Xlr8"}`), actual: gzipped(t, `{"name": "Alien-X"}`), header: gz, pass: false, typ: run.BodyTypeJSON},
+		// malformed gzip is compared raw
+		{exp: "not gzip", actual: "not gzip", header: gz, pass: true, typ: run.BodyTypePlain},
+		{exp: "not gzip", actual: "still not gzip", header: gz, pass: false, typ: run.BodyTypePlain},
+		// the bodies aren't decompressed without the header
+		{exp: gzipped(t, `{"name": "Xlr8", "power": 9000}`), actual: gzipped(t, `{"power": 9000, "name": "Xlr8"}`), pass: false, typ: run.BodyTypePlain},
+	} {
+		r := newTestRegression(models.TestCase{
+			ID:       "1",
+			CID:      "cid",
+			HttpResp: models.HttpResp{StatusCode: 200, Header: tt.header, Body: tt.exp},
+			Noise:    tt.noise,
+		})
+		pass, res, _, err := r.test(context.Background(), "cid", "1", "app", models.HttpResp{StatusCode: 200, Header: tt.header, Body: tt.actual})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pass != tt.pass || res.BodyResult.Type != tt.typ {
+			t.Fatal("THIS IS EXP", tt.pass, tt.typ, " \n THIS IS ACT", pass, res.BodyResult.Type, tt.header)
+		}
+	}
+}
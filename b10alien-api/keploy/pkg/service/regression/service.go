@@ -9,11 +9,17 @@ import (
 
 type Service interface {
 	Get(ctx context.Context, cid, appID, id string) (models.TestCase, error)
-	GetAll(ctx context.Context, cid, appID string, offset *int, limit *int) ([]models.TestCase, error)
-	Put(ctx context.Context, cid string, t []models.TestCase) ([]string, error)
+	GetAll(ctx context.Context, cid, appID string, tags []string, offset *int, limit *int) ([]models.TestCase, error)
+	Put(ctx context.Context, cid string, t []models.TestCase) (PutResult, error)
+	DryRunPut(ctx context.Context, cid string, t []models.TestCase) ([]DedupReport, error)
 	DeNoise(ctx context.Context, cid, id, app, body string, h http.Header) error
+	DeNoiseSamples(ctx context.Context, cid, id, app string, samples []models.HttpResp) error
+	DeNoiseRequest(ctx context.Context, cid, id, app string, req models.HttpReq) error
 	Test(ctx context.Context, cid, app, runID, id string, resp models.HttpResp) (bool, error)
 	GetApps(ctx context.Context, cid string) ([]string, error)
 	UpdateTC(ctx context.Context, t []models.TestCase) error
 	DeleteTC(ctx context.Context, cid, id string) error
+	DeleteTCs(ctx context.Context, cid string, ids []string) ([]DeleteResult, error)
+	NoisyFields(ctx context.Context, cid, app string, method models.Method, uri string) ([]string, error)
+	ResetCache(cid, appID string)
 }
@@ -0,0 +1,137 @@
+package run
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// junitSnippetLen is the length of the expected and actual body snippets of a failure.
+const junitSnippetLen = 512
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Details string `xml:",chardata"`
+}
+
+// JUnit renders the tests of the given run as a JUnit XML test suite, so that CI systems can
+// report them. Every test is a testcase, the failed ones describe the mismatches of their result.
+func (r *Run) JUnit(ctx context.Context, cid, runID string) ([]byte, error) {
+	runs, err := r.rdb.Read(ctx, cid, nil, nil, &runID, nil, nil, 0, 1)
+	if err != nil {
+		r.log.Error("failed to read test run from DB", zap.String("cid", cid), zap.String("test run id", runID), zap.Error(err))
+		return nil, errors.New("failed getting test run")
+	}
+	if len(runs) == 0 {
+		return nil, errors.New("test run not found")
+	}
+	tests, err := r.rdb.ReadTests(ctx, runID)
+	if err != nil {
+		r.log.Error("failed getting tests from DB", zap.String("cid", cid), zap.String("test run id", runID), zap.Error(err))
+		return nil, errors.New("failed getting tests from DB")
+	}
+	// keep the order of execution
+	sort.SliceStable(tests, func(i, j int) bool { return tests[i].Started < tests[j].Started })
+
+	tr := runs[0]
+	suite := junitTestSuite{Name: tr.App, Tests: len(tests)}
+	var total int64
+	for _, t := range tests {
+		tc := junitTestCase{
+			Name:      fmt.Sprintf("%s %s (%s)", t.Req.Method, t.URI, t.TestCaseID),
+			ClassName: tr.App,
+			Time:      strconv.FormatInt(t.Completed-t.Started, 10),
+		}
+		total += t.Completed - t.Started
+		if t.Status != TestStatusPassed {
+			suite.Failures++
+			tc.Failure = junitFailureOf(t)
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	suite.Time = strconv.FormatInt(total, 10)
+	if tr.Created != 0 {
+		suite.Timestamp = time.Unix(tr.Created, 0).UTC().Format("2006-01-02T15:04:05")
+	}
+
+	out, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// junitFailureOf describes the mismatched status code, headers and body of a failed test.
+func junitFailureOf(t Test) *junitFailure {
+	var mismatches, details []string
+	res := t.Result
+	if !res.StatusCode.Normal && res.StatusCode.Expected != 0 {
+		mismatches = append(mismatches, "status code")
+		details = append(details, fmt.Sprintf("status code: expected %d, actual %d", res.StatusCode.Expected, res.StatusCode.Actual))
+	}
+	if res.Latency != nil && !res.Latency.Normal {
+		mismatches = append(mismatches, "latency")
+		if res.Latency.Actual == 0 {
+			details = append(details, fmt.Sprintf("latency: max %dms, not reported by the client", res.Latency.Expected))
+		} else {
+			details = append(details, fmt.Sprintf("latency: max %dms, actual %dms", res.Latency.Expected, res.Latency.Actual))
+		}
+	}
+	for _, h := range res.HeadersResult {
+		if h.Normal {
+			continue
+		}
+		mismatches = append(mismatches, "header "+h.Expected.Key)
+		details = append(details, fmt.Sprintf("header %s: expected %v, actual %v", h.Expected.Key, h.Expected.Value, h.Actual.Value))
+	}
+	if !res.BodyResult.Normal && (res.BodyResult.Expected != "" || res.BodyResult.Actual != "") {
+		mismatches = append(mismatches, "body")
+		for _, d := range res.BodyResult.Diffs {
+			details = append(details, fmt.Sprintf("%s: expected %v, actual %v", d.Key, d.Expected, d.Actual))
+		}
+		details = append(details,
+			"expected body: "+snippet(res.BodyResult.Expected),
+			"actual body: "+snippet(res.BodyResult.Actual))
+	}
+	if len(mismatches) == 0 {
+		// eg: the testcase couldn't be read
+		return &junitFailure{Message: "test failed", Type: "error"}
+	}
+	return &junitFailure{
+		Message: strings.Join(mismatches, ", ") + " mismatch",
+		Type:    "mismatch",
+		Details: strings.Join(details, "\n"),
+	}
+}
+
+func snippet(s string) string {
+	if len(s) <= junitSnippetLen {
+		return s
+	}
+	return s[:junitSnippetLen] + "..."
+}
@@ -3,21 +3,33 @@ package run
 import (
 	"context"
 	"errors"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"go.keploy.io/server/pkg/models"
 	"go.keploy.io/server/pkg/platform/telemetry"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
-func New(rdb DB, tdb models.TestCaseDB, log *zap.Logger, adb telemetry.Service, cl http.Client) *Run {
+// readTestsConcurrency is the number of test runs whose tests are read from the DB at once.
+const readTestsConcurrency = 8
+
+func New(rdb DB, tdb models.TestCaseDB, tester Tester, log *zap.Logger, adb telemetry.Service, cl http.Client) *Run {
 	return &Run{
-		tele:   adb,
+		tele:   telemetry.FromEnv(adb),
 		rdb:    rdb,
 		tdb:    tdb,
+		tester: tester,
 		client: cl,
 		log:    log,
+
+		StaleTimeout: 5 * time.Minute,
 	}
 }
 
@@ -26,8 +38,12 @@ type Run struct {
 	runCount int
 	rdb      DB
 	tdb      models.TestCaseDB
+	tester   Tester
 	client   http.Client
 	log      *zap.Logger
+
+	// StaleTimeout is the time after which a running test run without any new test is failed.
+	StaleTimeout time.Duration
 }
 
 func (r *Run) Normalize(ctx context.Context, cid, id string) error {
@@ -69,6 +85,9 @@ func (r *Run) Get(ctx context.Context, summary bool, cid string, user, app, id *
 	if err != nil {
 		return nil, err
 	}
+	for _, v := range res {
+		v.PassRate = passRate(v)
+	}
 	if summary {
 		return res, nil
 	}
@@ -76,14 +95,26 @@ func (r *Run) Get(ctx context.Context, summary bool, cid string, user, app, id *
 		return res, nil
 	}
 
+	// the tests of the runs are read concurrently, each run keeps its place in res
+	g, gctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, readTestsConcurrency)
 	for _, v := range res {
-		tests, err1 := r.rdb.ReadTests(ctx, v.ID)
-		if err1 != nil {
-			msg := "failed getting tests from DB"
-			r.log.Error(msg, zap.String("cid", cid), zap.String("test run id", v.ID), zap.Error(err1))
-			return nil, errors.New(msg)
-		}
-		v.Tests = tests
+		v := v
+		sem <- struct{}{}
+		g.Go(func() error {
+			defer func() { <-sem }()
+			tests, err1 := r.rdb.ReadTests(gctx, v.ID)
+			if err1 != nil {
+				msg := "failed getting tests from DB"
+				r.log.Error(msg, zap.String("cid", cid), zap.String("test run id", v.ID), zap.Error(err1))
+				return errors.New(msg)
+			}
+			v.Tests = tests
+			return nil
+		})
+	}
+	if err = g.Wait(); err != nil {
+		return nil, err
 	}
 	return res, nil
 }
@@ -98,16 +129,17 @@ func (r *Run) updateStatus(ctx context.Context, trs []*TestRun) error {
 			tests++
 			continue
 		}
-		tests, err1 := r.rdb.ReadTests(ctx, tr.ID)
+		// the tests are counted in the DB, the summaries don't read them
+		stats, err1 := r.rdb.Stats(ctx, tr.ID)
 
 		if err1 != nil {
 			msg := "failed getting tests from DB"
 			r.log.Error(msg, zap.String("cid", tr.CID), zap.String("test run id", tr.ID), zap.Error(err1))
 			return errors.New(msg)
 		}
-		if len(tests) == 0 {
+		if stats.Total == 0 {
 
-			// check if the testrun is more than 5 mins old
+			// check if the testrun is older than the stale timeout
 			err := r.failOldTestRuns(ctx, tr.Created, tr)
 			if err != nil {
 				return err
@@ -115,15 +147,8 @@ func (r *Run) updateStatus(ctx context.Context, trs []*TestRun) error {
 			continue
 
 		}
-		// find the newest test
-		ts := tests[0].Started
-		for _, test := range tests {
-			if test.Started > ts {
-				ts = test.Started
-			}
-		}
-		// if the oldest test is older than 5 minutes then fail the whole test run
-		err := r.failOldTestRuns(ctx, ts, tr)
+		// if the newest test is older than the stale timeout then fail the whole test run
+		err := r.failOldTestRuns(ctx, stats.LastStarted, tr)
 		if err != nil {
 			return err
 		}
@@ -142,9 +167,18 @@ func (r *Run) updateStatus(ctx context.Context, trs []*TestRun) error {
 	return nil
 }
 
+// passRate returns Success/(Success+Failure), it is 0 for a run without any completed test.
+func passRate(tr *TestRun) float64 {
+	completed := tr.Success + tr.Failure
+	if completed == 0 {
+		return 0
+	}
+	return float64(tr.Success) / float64(completed)
+}
+
 func (r *Run) failOldTestRuns(ctx context.Context, ts int64, tr *TestRun) error {
 	diff := time.Now().UTC().Sub(time.Unix(ts, 0))
-	if diff < 5*time.Minute {
+	if diff < r.StaleTimeout {
 		return nil
 	}
 	tr.Status = TestRunStatusFailed
@@ -161,3 +195,260 @@ func (r *Run) failOldTestRuns(ctx context.Context, ts int64, tr *TestRun) error
 func (r *Run) Put(ctx context.Context, run TestRun) error {
 	return r.rdb.Upsert(ctx, run)
 }
+
+// Replay sends the captured requests of every test in the given run to targetURL and compares the
+// responses with the expected responses of the testcases. The results are recorded under a new
+// test run whose id is returned. Tests which could not be replayed are reported in ReplayResult.Failed
+// and counted as failures of the new run.
+func (r *Run) Replay(ctx context.Context, cid, runID, targetURL string) (*ReplayResult, error) {
+	target, err := url.Parse(targetURL)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, errors.New("invalid target url")
+	}
+	runs, err := r.rdb.Read(ctx, cid, nil, nil, &runID, nil, nil, 0, 1)
+	if err != nil {
+		r.log.Error("failed to read test run from DB", zap.String("cid", cid), zap.String("test run id", runID), zap.Error(err))
+		return nil, errors.New("failed getting test run")
+	}
+	if len(runs) == 0 {
+		return nil, errors.New("test run not found")
+	}
+	// a retry run has no tests of its own, its testcases are replayed from the tests of its parent
+	source := runs[0]
+	readID := runID
+	if source.ParentID != "" && len(source.TestCaseIDs) > 0 {
+		readID = source.ParentID
+	}
+	tests, err := r.rdb.ReadTests(ctx, readID)
+	if err != nil {
+		r.log.Error("failed getting tests from DB", zap.String("cid", cid), zap.String("test run id", readID), zap.Error(err))
+		return nil, errors.New("failed getting tests from DB")
+	}
+	if readID != runID {
+		tests = testsOf(tests, source.TestCaseIDs)
+	}
+
+	now := time.Now().Unix()
+	return r.replay(ctx, target, TestRun{
+		ID:          uuid.New().String(),
+		Created:     now,
+		Updated:     now,
+		Status:      TestRunStatusRunning,
+		CID:         cid,
+		App:         source.App,
+		User:        source.User,
+		Total:       len(tests),
+		ParentID:    source.ParentID,
+		TestCaseIDs: source.TestCaseIDs,
+	}, tests)
+}
+
+// replay creates the test run tr and records under it the results of the tests replayed against
+// the target. The run is completed once every test is replayed.
+func (r *Run) replay(ctx context.Context, target *url.URL, tr TestRun, tests []Test) (*ReplayResult, error) {
+	cid := tr.CID
+	err := r.rdb.Upsert(ctx, tr)
+	if err != nil {
+		r.log.Error("failed to create test run", zap.String("cid", cid), zap.String("test run id", tr.ID), zap.String("parent test run id", tr.ParentID), zap.Error(err))
+		return nil, errors.New("failed creating test run")
+	}
+
+	res := &ReplayResult{RunID: tr.ID, Failed: map[string]string{}}
+	for _, t := range tests {
+		pass, err := r.replayTest(ctx, cid, tr.App, tr.ID, target, t)
+		if err != nil {
+			r.log.Error("failed to replay test", zap.String("cid", cid), zap.String("test id", t.ID), zap.String("test run id", tr.ID), zap.Error(err))
+			res.Failed[t.ID] = err.Error()
+			continue
+		}
+		if !pass {
+			tr.Failure++
+			continue
+		}
+		tr.Success++
+	}
+
+	tr.Status = TestRunStatusPassed
+	if tr.Failure > 0 || len(res.Failed) > 0 {
+		tr.Status = TestRunStatusFailed
+	}
+	// the success and failure counts are incremented while saving each test result.
+	err = r.rdb.Upsert(ctx, TestRun{ID: tr.ID, Updated: time.Now().Unix(), Status: tr.Status})
+	if err != nil {
+		r.log.Error("failed to update test run status", zap.String("cid", cid), zap.String("test run id", tr.ID), zap.Error(err))
+		return res, errors.New("failed updating test run status")
+	}
+	return res, nil
+}
+
+// RetryFailed replays only the failed testcases of the given test run against targetURL, so that
+// they are checked once fixed without running the whole suite again. The results are recorded
+// under a child run which records the id of its parent run, replaying the child again only sends
+// the requests of those testcases.
+func (r *Run) RetryFailed(ctx context.Context, cid, runID, targetURL string) (*ReplayResult, error) {
+	target, err := url.Parse(targetURL)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, errors.New("invalid target url")
+	}
+	runs, err := r.rdb.Read(ctx, cid, nil, nil, &runID, nil, nil, 0, 1)
+	if err != nil {
+		r.log.Error("failed to read test run from DB", zap.String("cid", cid), zap.String("test run id", runID), zap.Error(err))
+		return nil, errors.New("failed getting test run")
+	}
+	if len(runs) == 0 {
+		return nil, errors.New("test run not found")
+	}
+	tests, err := r.rdb.ReadTests(ctx, runID)
+	if err != nil {
+		r.log.Error("failed getting tests from DB", zap.String("cid", cid), zap.String("test run id", runID), zap.Error(err))
+		return nil, errors.New("failed getting tests from DB")
+	}
+	var ids []string
+	for _, t := range tests {
+		if t.Status == TestStatusFailed {
+			ids = append(ids, t.TestCaseID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("test run has no failed tests")
+	}
+	tests = testsOf(tests, ids)
+	ids = ids[:0]
+	for _, t := range tests {
+		ids = append(ids, t.TestCaseID)
+	}
+	sort.Strings(ids)
+
+	now := time.Now().Unix()
+	return r.replay(ctx, target, TestRun{
+		ID:          uuid.New().String(),
+		Created:     now,
+		Updated:     now,
+		Status:      TestRunStatusRunning,
+		CID:         cid,
+		App:         runs[0].App,
+		User:        runs[0].User,
+		Total:       len(tests),
+		ParentID:    runID,
+		TestCaseIDs: ids,
+	}, tests)
+}
+
+// testsOf returns the tests of the given testcases, a single one per testcase.
+func testsOf(tests []Test, ids []string) []Test {
+	want := map[string]bool{}
+	for _, id := range ids {
+		want[id] = true
+	}
+	var res []Test
+	for _, t := range tests {
+		if want[t.TestCaseID] {
+			// a testcase tested twice in the run is replayed once
+			want[t.TestCaseID] = false
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// DeleteRun deletes the given test run with all its tests and returns the number of deleted tests.
+func (r *Run) DeleteRun(ctx context.Context, cid, runID string) (int64, error) {
+	runs, err := r.rdb.Read(ctx, cid, nil, nil, &runID, nil, nil, 0, 1)
+	if err != nil {
+		r.log.Error("failed to read test run from DB", zap.String("cid", cid), zap.String("test run id", runID), zap.Error(err))
+		return 0, errors.New("failed getting test run")
+	}
+	if len(runs) == 0 {
+		return 0, errors.New("test run not found")
+	}
+	count, err := r.rdb.DeleteRun(ctx, runID)
+	if err != nil {
+		r.log.Error("failed to delete test run from DB", zap.String("cid", cid), zap.String("test run id", runID), zap.Int64("deleted tests", count), zap.Error(err))
+		return count, errors.New("failed deleting test run")
+	}
+	// a replay still in flight can put a test after the tests of the run were deleted, it would be
+	// orphaned with the run gone
+	orphans, err := r.rdb.ReadTests(ctx, runID)
+	if err != nil {
+		r.log.Error("failed to read the orphaned tests from DB", zap.String("cid", cid), zap.String("test run id", runID), zap.Error(err))
+		return count, errors.New("failed deleting the orphaned tests")
+	}
+	for _, t := range orphans {
+		if err := r.rdb.DeleteTest(ctx, t.ID); err != nil {
+			r.log.Warn("failed to delete an orphaned test from DB", zap.String("cid", cid), zap.String("test run id", runID), zap.String("test id", t.ID), zap.Error(err))
+			continue
+		}
+		count++
+	}
+	r.log.Info("deleted test run", zap.String("cid", cid), zap.String("test run id", runID), zap.Int64("deleted tests", count))
+	return count, nil
+}
+
+// DeleteBefore deletes the test runs of the company created before the cutoff with all their
+// tests, eg: periodically to drop the old runs. It returns the numbers of deleted runs and tests.
+func (r *Run) DeleteBefore(ctx context.Context, cid string, cutoff time.Time) (int64, int64, error) {
+	runs, tests, err := r.rdb.DeleteBefore(ctx, cid, cutoff)
+	if err != nil {
+		r.log.Error("failed to delete old test runs from DB", zap.String("cid", cid), zap.Time("cutoff", cutoff), zap.Int64("deleted tests", tests), zap.Error(err))
+		return runs, tests, errors.New("failed deleting old test runs")
+	}
+	r.log.Info("deleted old test runs", zap.String("cid", cid), zap.Time("cutoff", cutoff), zap.Int64("deleted runs", runs), zap.Int64("deleted tests", tests))
+	return runs, tests, nil
+}
+
+// replayTest sends the captured request of t to the target and tests the response against its testcase.
+func (r *Run) replayTest(ctx context.Context, cid, app, runID string, target *url.URL, t Test) (bool, error) {
+	resp, err := r.send(ctx, target, t.Req)
+	if err != nil {
+		// count the test as failed in the new run since no result is recorded for it.
+		if err2 := r.rdb.Increment(ctx, false, true, runID); err2 != nil {
+			r.log.Error("failed to increment failure count", zap.String("test run id", runID), zap.Error(err2))
+		}
+		return false, err
+	}
+	return r.tester.Test(ctx, cid, app, runID, t.TestCaseID, resp)
+}
+
+// send makes the captured request against the target host and returns the received response.
+func (r *Run) send(ctx context.Context, target *url.URL, req models.HttpReq) (models.HttpResp, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return models.HttpResp{}, err
+	}
+	u.Scheme, u.Host, u.User = target.Scheme, target.Host, target.User
+	u.Path = strings.TrimSuffix(target.Path, "/") + u.Path
+	if len(req.URLParams) > 0 && u.RawQuery == "" {
+		q := url.Values{}
+		for k, v := range req.URLParams {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+	}
+	method := string(req.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, u.String(), strings.NewReader(req.Body))
+	if err != nil {
+		return models.HttpResp{}, err
+	}
+	for k, v := range req.Header {
+		httpReq.Header[k] = v
+	}
+	start := time.Now()
+	httpResp, err := r.client.Do(httpReq)
+	if err != nil {
+		return models.HttpResp{}, err
+	}
+	defer httpResp.Body.Close()
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return models.HttpResp{}, err
+	}
+	return models.HttpResp{
+		StatusCode: httpResp.StatusCode,
+		Header:     httpResp.Header,
+		Body:       string(body),
+		Latency:    time.Since(start).Milliseconds(),
+	}, nil
+}
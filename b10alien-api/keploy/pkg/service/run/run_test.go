@@ -0,0 +1,561 @@
+package run
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.keploy.io/server/pkg/models"
+	"go.uber.org/zap"
+)
+
+// mockDB is an in-memory implementation of DB.
+type mockDB struct {
+	runs  map[string]TestRun
+	tests map[string]Test
+}
+
+func newMockDB() *mockDB {
+	return &mockDB{runs: map[string]TestRun{}, tests: map[string]Test{}}
+}
+
+func (m *mockDB) Read(_ context.Context, cid string, _, _, id *string, _, _ *time.Time, _ int, _ int) ([]*TestRun, error) {
+	var res []*TestRun
+	for _, tr := range m.runs {
+		if tr.CID != cid || (id != nil && tr.ID != *id) {
+			continue
+		}
+		tr := tr
+		res = append(res, &tr)
+	}
+	return res, nil
+}
+
+// Upsert mimics the $set update of mongo where empty fields are omitted.
+func (m *mockDB) Upsert(_ context.Context, tr TestRun) error {
+	old, ok := m.runs[tr.ID]
+	if !ok {
+		m.runs[tr.ID] = tr
+		return nil
+	}
+	if tr.Created != 0 {
+		old.Created = tr.Created
+	}
+	if tr.Updated != 0 {
+		old.Updated = tr.Updated
+	}
+	if tr.CID != "" {
+		old.CID = tr.CID
+	}
+	if tr.App != "" {
+		old.App = tr.App
+	}
+	if tr.User != "" {
+		old.User = tr.User
+	}
+	if tr.Success != 0 {
+		old.Success = tr.Success
+	}
+	if tr.Failure != 0 {
+		old.Failure = tr.Failure
+	}
+	if tr.Total != 0 {
+		old.Total = tr.Total
+	}
+	old.Status = tr.Status
+	m.runs[tr.ID] = old
+	return nil
+}
+
+func (m *mockDB) ReadTest(_ context.Context, id string) (Test, error) {
+	t, ok := m.tests[id]
+	if !ok {
+		return t, errors.New("no documents in result")
+	}
+	return t, nil
+}
+
+func (m *mockDB) ReadTests(_ context.Context, runID string) ([]Test, error) {
+	var res []Test
+	for _, t := range m.tests {
+		if t.RunID == runID {
+			res = append(res, t)
+		}
+	}
+	return res, nil
+}
+
+func (m *mockDB) Stats(_ context.Context, runID string) (TestStats, error) {
+	var stats TestStats
+	for _, t := range m.tests {
+		if t.RunID != runID {
+			continue
+		}
+		stats.Total++
+		if t.Started > stats.LastStarted {
+			stats.LastStarted = t.Started
+		}
+		switch t.Status {
+		case TestStatusPassed:
+			stats.Passed++
+		case TestStatusFailed:
+			stats.Failed++
+		case TestStatusRunning:
+			stats.Running++
+		case TestStatusPending:
+			stats.Pending++
+		}
+	}
+	return stats, nil
+}
+
+func (m *mockDB) DeleteTest(_ context.Context, id string) error {
+	if _, ok := m.tests[id]; !ok {
+		return errors.New("document not found")
+	}
+	delete(m.tests, id)
+	return nil
+}
+
+func (m *mockDB) DeleteRun(_ context.Context, runID string) (int64, error) {
+	var count int64
+	for id, t := range m.tests {
+		if t.RunID == runID {
+			delete(m.tests, id)
+			count++
+		}
+	}
+	delete(m.runs, runID)
+	return count, nil
+}
+
+func (m *mockDB) DeleteBefore(_ context.Context, cid string, cutoff time.Time) (int64, int64, error) {
+	var runs, tests int64
+	for id, tr := range m.runs {
+		if tr.CID != cid || tr.Created == 0 || tr.Created >= cutoff.Unix() {
+			continue
+		}
+		for tid, t := range m.tests {
+			if t.RunID == id {
+				delete(m.tests, tid)
+				tests++
+			}
+		}
+		delete(m.runs, id)
+		runs++
+	}
+	return runs, tests, nil
+}
+
+func (m *mockDB) PutTest(_ context.Context, t Test) error {
+	m.tests[t.ID] = t
+	return nil
+}
+
+func (m *mockDB) Increment(_ context.Context, success, failure bool, id string) error {
+	tr := m.runs[id]
+	tr.ID = id
+	if success {
+		tr.Success++
+	}
+	if failure {
+		tr.Failure++
+	}
+	m.runs[id] = tr
+	return nil
+}
+
+// mockTester passes a response if it matches the expected response of the testcase and
+// saves the result the same way as the regression service.
+type mockTester struct {
+	rdb *mockDB
+	exp map[string]models.HttpResp
+}
+
+func (m *mockTester) Test(ctx context.Context, _, _, runID, id string, resp models.HttpResp) (bool, error) {
+	pass := m.exp[id].StatusCode == resp.StatusCode && m.exp[id].Body == resp.Body
+	status := TestStatusFailed
+	if pass {
+		status = TestStatusPassed
+	}
+	m.rdb.PutTest(ctx, Test{ID: uuid.New().String(), RunID: runID, TestCaseID: id, Status: status, Resp: resp})
+	return pass, m.rdb.Increment(ctx, pass, !pass, runID)
+}
+
+// mockTelemetry records the number of telemetry events it receives.
+type mockTelemetry struct {
+	events int
+}
+
+func (m *mockTelemetry) Ping(bool)                                      {}
+func (m *mockTelemetry) Normalize(http.Client, context.Context)         { m.events++ }
+func (m *mockTelemetry) EditTc(http.Client, context.Context)            { m.events++ }
+func (m *mockTelemetry) Testrun(int, int, http.Client, context.Context) { m.events++ }
+func (m *mockTelemetry) DeleteTc(http.Client, context.Context)          { m.events++ }
+func (m *mockTelemetry) GetApps(int, http.Client, context.Context)      { m.events++ }
+
+func newTestRun(rdb *mockDB, tester Tester) *Run {
+	logger, _ := zap.NewDevelopment()
+	return New(rdb, nil, tester, logger, &mockTelemetry{}, http.Client{})
+}
+
+func TestReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/api/b10aliens":
+			w.Write([]byte(r.Method + " " + r.URL.RawQuery + " " + string(body)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	rdb := newMockDB()
+	rdb.runs["run-1"] = TestRun{ID: "run-1", CID: "cid", App: "b10alien-api", Status: TestRunStatusFailed, Total: 3}
+	rdb.tests["t1"] = Test{ID: "t1", RunID: "run-1", TestCaseID: "tc1", Req: models.HttpReq{Method: models.MethodGet, URL: "/b10aliens?min_power=2000"}}
+	rdb.tests["t2"] = Test{ID: "t2", RunID: "run-1", TestCaseID: "tc2", Req: models.HttpReq{Method: models.MethodPost, URL: "/b10aliens", Body: `{"name":"Xlr8"}`}}
+	rdb.tests["t3"] = Test{ID: "t3", RunID: "run-1", TestCaseID: "tc3", Req: models.HttpReq{Method: models.MethodGet, URL: "/unknown"}}
+	tester := &mockTester{rdb: rdb, exp: map[string]models.HttpResp{
+		"tc1": {StatusCode: 200, Body: "GET min_power=2000 "},
+		"tc2": {StatusCode: 200, Body: `POST  {"name":"Xlr8"}`},
+		"tc3": {StatusCode: 200},
+	}}
+
+	res, err := newTestRun(rdb, tester).Replay(context.Background(), "cid", "run-1", srv.URL+"/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RunID == "" || res.RunID == "run-1" {
+		t.Fatal("expected a new test run id, got", res.RunID)
+	}
+	if len(res.Failed) != 0 {
+		t.Fatal("unexpected replay failures", res.Failed)
+	}
+	tests, _ := rdb.ReadTests(context.Background(), res.RunID)
+	if len(tests) != 3 {
+		t.Fatal("THIS IS EXP", 3, " \n THIS IS ACT", len(tests))
+	}
+	tr := rdb.runs[res.RunID]
+	if tr.Total != 3 || tr.Success != 2 || tr.Failure != 1 || tr.Status != TestRunStatusFailed || tr.App != "b10alien-api" {
+		t.Fatal("unexpected test run", tr)
+	}
+	// the original run is left untouched
+	if old, _ := rdb.ReadTests(context.Background(), "run-1"); len(old) != 3 {
+		t.Fatal("original test run was modified")
+	}
+}
+
+func TestReplayPartialFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	rdb := newMockDB()
+	rdb.runs["run-1"] = TestRun{ID: "run-1", CID: "cid", App: "app"}
+	rdb.tests["t1"] = Test{ID: "t1", RunID: "run-1", TestCaseID: "tc1", Req: models.HttpReq{Method: models.MethodGet, URL: "/"}}
+	rdb.tests["t2"] = Test{ID: "t2", RunID: "run-1", TestCaseID: "tc2", Req: models.HttpReq{Method: "BAD METHOD", URL: "/"}}
+	tester := &mockTester{rdb: rdb, exp: map[string]models.HttpResp{"tc1": {StatusCode: 200, Body: "ok"}}}
+
+	run := newTestRun(rdb, tester)
+	res, err := run.Replay(context.Background(), "cid", "run-1", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.Failed["t2"]; !ok || len(res.Failed) != 1 {
+		t.Fatal("expected t2 to be reported as failed, got", res.Failed)
+	}
+	tr := rdb.runs[res.RunID]
+	if tr.Success != 1 || tr.Failure != 1 || tr.Status != TestRunStatusFailed {
+		t.Fatal("unexpected test run", tr)
+	}
+
+	if _, err := run.Replay(context.Background(), "cid", "missing", srv.URL); err == nil {
+		t.Fatal("expected error for a missing test run")
+	}
+	if _, err := run.Replay(context.Background(), "cid", "run-1", "localhost"); err == nil {
+		t.Fatal("expected error for an invalid target url")
+	}
+}
+
+func TestJUnit(t *testing.T) {
+	rdb := newMockDB()
+	rdb.runs["run-1"] = TestRun{ID: "run-1", CID: "cid", App: "b10alien-api", Created: 1665396000}
+	rdb.tests["t1"] = Test{ID: "t1", RunID: "run-1", TestCaseID: "tc1", URI: "/b10aliens", Started: 10, Completed: 11, Status: TestStatusPassed,
+		Req: models.HttpReq{Method: models.MethodGet}}
+	rdb.tests["t2"] = Test{ID: "t2", RunID: "run-1", TestCaseID: "tc2", URI: "/b10aliens/1", Started: 12, Completed: 14, Status: TestStatusFailed,
+		Req: models.HttpReq{Method: models.MethodGet},
+		Result: Result{
+			StatusCode: IntResult{Normal: false, Expected: 200, Actual: 404},
+			HeadersResult: []HeaderResult{
+				{Normal: true, Expected: Header{Key: "Content-Type", Value: []string{"application/json"}}, Actual: Header{Key: "Content-Type", Value: []string{"application/json"}}},
+				{Normal: false, Expected: Header{Key: "Etag", Value: []string{"1"}}, Actual: Header{Key: "Etag", Value: []string{"2"}}},
+			},
+			BodyResult: BodyResult{Normal: false, Type: BodyTypeJSON, Expected: `{"name":"Xlr8"}`, Actual: `{"error":"<not found>"}`},
+		}}
+	// the testcase of t3 couldn't be read, so its result is empty
+	rdb.tests["t3"] = Test{ID: "t3", RunID: "run-1", TestCaseID: "tc3", Started: 15, Completed: 15, Status: TestStatusFailed}
+
+	out, err := newTestRun(rdb, nil).JUnit(context.Background(), "cid", "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suite junitTestSuite
+	if err := xml.Unmarshal(out, &suite); err != nil {
+		t.Fatal(err, string(out))
+	}
+	if suite.Name != "b10alien-api" || suite.Tests != 3 || suite.Failures != 2 || suite.Time != "3" || suite.Timestamp != "2022-10-10T10:00:00" {
+		t.Fatal("unexpected test suite", suite)
+	}
+	tcs := suite.TestCases
+	if len(tcs) != 3 || tcs[0].Name != "GET /b10aliens (tc1)" || tcs[0].Failure != nil {
+		t.Fatal("unexpected testcases", tcs)
+	}
+	f := tcs[1].Failure
+	if f == nil || f.Message != "status code, header Etag, body mismatch" {
+		t.Fatal("unexpected failure", f)
+	}
+	for _, s := range []string{"status code: expected 200, actual 404", "header Etag: expected [1], actual [2]", `actual body: {"error":"<not found>"}`} {
+		if !strings.Contains(f.Details, s) {
+			t.Fatal("THIS IS EXP", s, " \n THIS IS ACT", f.Details)
+		}
+	}
+	if f := tcs[2].Failure; f == nil || f.Message != "test failed" {
+		t.Fatal("unexpected failure", f)
+	}
+
+	if _, err := newTestRun(rdb, nil).JUnit(context.Background(), "cid", "missing"); err == nil {
+		t.Fatal("expected error for a missing test run")
+	}
+}
+
+func TestStaleTimeout(t *testing.T) {
+	now := time.Now().Unix()
+	for _, tt := range []struct {
+		timeout time.Duration
+		status  TestRunStatus
+	}{
+		{timeout: time.Second, status: TestRunStatusFailed},
+		{timeout: time.Hour, status: TestRunStatusRunning},
+	} {
+		rdb := newMockDB()
+		rdb.runs["run-1"] = TestRun{ID: "run-1", CID: "cid", Status: TestRunStatusRunning, Created: now - 10}
+		rdb.runs["run-2"] = TestRun{ID: "run-2", CID: "cid", Status: TestRunStatusRunning, Created: now - 10}
+		rdb.tests["t-1"] = Test{ID: "t-1", RunID: "run-2", Started: now - 5}
+		// the summaries count the tests without reading them
+		logger, _ := zap.NewDevelopment()
+		run := New(failingTestsDB{mockDB: rdb, runID: "run-2"}, nil, nil, logger, &mockTelemetry{}, http.Client{})
+		run.StaleTimeout = tt.timeout
+		if _, err := run.Get(context.Background(), true, "cid", nil, nil, nil, nil, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		// both the runs without tests and the runs whose newest test is too old are failed
+		for _, id := range []string{"run-1", "run-2"} {
+			if rdb.runs[id].Status != tt.status {
+				t.Fatal("THIS IS EXP", tt.status, " \n THIS IS ACT", rdb.runs[id].Status, id, tt.timeout)
+			}
+		}
+	}
+}
+
+// failingTestsDB fails to read the tests of the given run.
+type failingTestsDB struct {
+	*mockDB
+	runID string
+}
+
+func (f failingTestsDB) ReadTests(ctx context.Context, runID string) ([]Test, error) {
+	if runID == f.runID {
+		return nil, errors.New("connection reset")
+	}
+	return f.mockDB.ReadTests(ctx, runID)
+}
+
+func TestGetTests(t *testing.T) {
+	rdb := newMockDB()
+	for i := 0; i < 3*readTestsConcurrency; i++ {
+		id := fmt.Sprintf("run-%d", i)
+		rdb.runs[id] = TestRun{ID: id, CID: "cid", Status: TestRunStatusPassed}
+		rdb.tests["t-"+id] = Test{ID: "t-" + id, RunID: id}
+	}
+	runs, err := newTestRun(rdb, nil).Get(context.Background(), false, "cid", nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 3*readTestsConcurrency {
+		t.Fatal("THIS IS EXP", 3*readTestsConcurrency, " \n THIS IS ACT", len(runs))
+	}
+	for _, tr := range runs {
+		if len(tr.Tests) != 1 || tr.Tests[0].RunID != tr.ID {
+			t.Fatal("THIS IS EXP", tr.ID, " \n THIS IS ACT", tr.Tests)
+		}
+	}
+
+	logger, _ := zap.NewDevelopment()
+	run := New(failingTestsDB{mockDB: rdb, runID: "run-5"}, nil, nil, logger, &mockTelemetry{}, http.Client{})
+	if _, err := run.Get(context.Background(), false, "cid", nil, nil, nil, nil, nil, nil, nil); err == nil {
+		t.Fatal("expected the error of the failed read")
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	rdb := newMockDB()
+	rdb.runs["run-1"] = TestRun{ID: "run-1", CID: "cid", App: "app", User: "user", Status: TestRunStatusFailed}
+	rdb.tests["t-1"] = Test{ID: "t-1", RunID: "run-1", TestCaseID: "tc-2", Status: TestStatusFailed, Req: models.HttpReq{Method: models.MethodGet, URL: "/tc-2"}}
+	rdb.tests["t-2"] = Test{ID: "t-2", RunID: "run-1", TestCaseID: "tc-1", Status: TestStatusPassed, Req: models.HttpReq{Method: models.MethodGet, URL: "/tc-1"}}
+	rdb.tests["t-3"] = Test{ID: "t-3", RunID: "run-1", TestCaseID: "tc-3", Status: TestStatusFailed, Req: models.HttpReq{Method: models.MethodGet, URL: "/tc-3"}}
+	rdb.runs["run-2"] = TestRun{ID: "run-2", CID: "cid", Status: TestRunStatusPassed}
+	rdb.tests["t-4"] = Test{ID: "t-4", RunID: "run-2", TestCaseID: "tc-1", Status: TestStatusPassed}
+	run := newTestRun(rdb, &mockTester{rdb: rdb, exp: map[string]models.HttpResp{"tc-2": {StatusCode: 200}, "tc-3": {StatusCode: 200}}})
+	var mu sync.Mutex
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sent = append(sent, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	// only the failed testcases are sent, the passed ones aren't
+	res, err := run.RetryFailed(context.Background(), "cid", "run-1", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(sent)
+	if exp := []string{"/tc-2", "/tc-3"}; !reflect.DeepEqual(sent, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", sent)
+	}
+	child, ok := rdb.runs[res.RunID]
+	if !ok {
+		t.Fatal("the child run isn't stored")
+	}
+	exp := []string{"tc-2", "tc-3"}
+	if child.ParentID != "run-1" || child.App != "app" || strings.Join(child.TestCaseIDs, ",") != strings.Join(exp, ",") {
+		t.Fatal("THIS IS EXP", "run-1", exp, " \n THIS IS ACT", child.ParentID, child.TestCaseIDs, child)
+	}
+	// the child run ends up with the results of the retried testcases
+	if child.Status != TestRunStatusPassed || child.Total != 2 || child.Success != 2 || child.Failure != 0 {
+		t.Fatal("THIS IS EXP", TestRunStatusPassed, 2, 2, " \n THIS IS ACT", child.Status, child.Total, child.Success, child)
+	}
+	if tests, _ := rdb.ReadTests(context.Background(), child.ID); len(tests) != 2 {
+		t.Fatal("THIS IS EXP", 2, " \n THIS IS ACT", tests)
+	}
+
+	// replaying the child again only sends the same testcases
+	sent = nil
+	if _, err := run.Replay(context.Background(), "cid", child.ID, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(sent)
+	if exp := []string{"/tc-2", "/tc-3"}; !reflect.DeepEqual(sent, exp) {
+		t.Fatal("THIS IS EXP", exp, " \n THIS IS ACT", sent)
+	}
+
+	if _, err := run.RetryFailed(context.Background(), "cid", "run-1", "not a url"); err == nil {
+		t.Fatal("expected error for an invalid target url")
+	}
+	for _, id := range []string{"run-2", "missing"} {
+		if _, err := run.RetryFailed(context.Background(), "cid", id, srv.URL); err == nil {
+			t.Fatal("expected error for the test run", id)
+		}
+	}
+}
+
+func TestDeleteRun(t *testing.T) {
+	rdb := newMockDB()
+	rdb.runs["run-1"] = TestRun{ID: "run-1", CID: "cid"}
+	rdb.runs["run-2"] = TestRun{ID: "run-2", CID: "cid"}
+	rdb.tests["t-1"] = Test{ID: "t-1", RunID: "run-1"}
+	rdb.tests["t-2"] = Test{ID: "t-2", RunID: "run-1"}
+	rdb.tests["t-3"] = Test{ID: "t-3", RunID: "run-2"}
+	run := newTestRun(rdb, nil)
+
+	count, err := run.DeleteRun(context.Background(), "cid", "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rdb.runs["run-1"]; count != 2 || ok || len(rdb.tests) != 1 || len(rdb.runs) != 1 {
+		t.Fatal("THIS IS EXP", 2, " \n THIS IS ACT", count, rdb.runs, rdb.tests)
+	}
+	// the runs of another company are left untouched
+	if _, err := run.DeleteRun(context.Background(), "other", "run-2"); err == nil || len(rdb.runs) != 1 {
+		t.Fatal("expected error for a missing test run", rdb.runs)
+	}
+
+	// the test put by a replay in flight while the run is deleted isn't left orphaned
+	logger, _ := zap.NewDevelopment()
+	count, err = New(racingDeleteDB{rdb}, nil, nil, logger, &mockTelemetry{}, http.Client{}).DeleteRun(context.Background(), "cid", "run-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || len(rdb.tests) != 0 || len(rdb.runs) != 0 {
+		t.Fatal("THIS IS EXP", 2, " \n THIS IS ACT", count, rdb.runs, rdb.tests)
+	}
+}
+
+// racingDeleteDB puts a test of the run right after deleting it, like a replay in flight.
+type racingDeleteDB struct {
+	*mockDB
+}
+
+func (m racingDeleteDB) DeleteRun(ctx context.Context, runID string) (int64, error) {
+	count, err := m.mockDB.DeleteRun(ctx, runID)
+	m.tests["late"] = Test{ID: "late", RunID: runID}
+	return count, err
+}
+
+func TestDeleteBefore(t *testing.T) {
+	rdb := newMockDB()
+	rdb.runs["old"] = TestRun{ID: "old", CID: "cid", Created: 100}
+	rdb.runs["new"] = TestRun{ID: "new", CID: "cid", Created: 300}
+	rdb.runs["other"] = TestRun{ID: "other", CID: "other", Created: 100}
+	rdb.tests["t-1"] = Test{ID: "t-1", RunID: "old"}
+	rdb.tests["t-2"] = Test{ID: "t-2", RunID: "old"}
+	rdb.tests["t-3"] = Test{ID: "t-3", RunID: "new"}
+
+	runs, tests, err := newTestRun(rdb, nil).DeleteBefore(context.Background(), "cid", time.Unix(200, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs != 1 || tests != 2 {
+		t.Fatal("THIS IS EXP", 1, 2, " \n THIS IS ACT", runs, tests)
+	}
+	if _, ok := rdb.runs["old"]; ok || len(rdb.runs) != 2 || len(rdb.tests) != 1 {
+		t.Fatal("THIS IS EXP", []string{"new", "other"}, " \n THIS IS ACT", rdb.runs, rdb.tests)
+	}
+}
+
+func TestPassRate(t *testing.T) {
+	rdb := newMockDB()
+	for _, tr := range []TestRun{
+		{ID: "run-1", Success: 3, Failure: 1},
+		{ID: "run-2", Success: 2},
+		{ID: "run-3", Failure: 2},
+		{ID: "run-4"},
+	} {
+		tr.CID, tr.Status = "cid", TestRunStatusPassed
+		rdb.runs[tr.ID] = tr
+	}
+	runs, err := newTestRun(rdb, nil).Get(context.Background(), true, "cid", nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]float64{"run-1": 0.75, "run-2": 1, "run-3": 0, "run-4": 0}
+	for _, tr := range runs {
+		if tr.PassRate != exp[tr.ID] {
+			t.Fatal("THIS IS EXP", exp[tr.ID], " \n THIS IS ACT", tr.PassRate, tr.ID)
+		}
+	}
+}
@@ -11,6 +11,16 @@ type Service interface {
 	Get(ctx context.Context, summary bool, cid string, user, app, id *string, from, to *time.Time, offset *int, limit *int) ([]*TestRun, error)
 	Put(ctx context.Context, run TestRun) error
 	Normalize(ctx context.Context, cid, id string) error
+	Replay(ctx context.Context, cid, runID, targetURL string) (*ReplayResult, error)
+	JUnit(ctx context.Context, cid, runID string) ([]byte, error)
+	RetryFailed(ctx context.Context, cid, runID, targetURL string) (*ReplayResult, error)
+	DeleteRun(ctx context.Context, cid, runID string) (int64, error)
+	DeleteBefore(ctx context.Context, cid string, cutoff time.Time) (int64, int64, error)
+}
+
+// Tester compares a response with the stored testcase and records the outcome under the given test run.
+type Tester interface {
+	Test(ctx context.Context, cid, app, runID, id string, resp models.HttpResp) (bool, error)
 }
 
 type DB interface {
@@ -20,6 +30,15 @@ type DB interface {
 	ReadTests(ctx context.Context, runID string) ([]Test, error)
 	PutTest(ctx context.Context, t Test) error
 	Increment(ctx context.Context, success, failure bool, id string) error
+	// Stats counts the tests of the run by status without reading them.
+	Stats(ctx context.Context, runID string) (TestStats, error)
+	// DeleteTest deletes a single test, eg: an orphaned test whose run is already deleted.
+	DeleteTest(ctx context.Context, id string) error
+	// DeleteRun deletes the test run and its tests, it returns the number of deleted tests.
+	DeleteRun(ctx context.Context, runID string) (int64, error)
+	// DeleteBefore deletes the test runs created before the cutoff and their tests, it returns the
+	// numbers of deleted runs and tests.
+	DeleteBefore(ctx context.Context, cid string, cutoff time.Time) (int64, int64, error)
 }
 
 type TestRun struct {
@@ -34,6 +53,31 @@ type TestRun struct {
 	Failure int           `json:"failure" bson:"failure,omitempty"`
 	Total   int           `json:"total" bson:"total,omitempty"`
 	Tests   []Test        `json:"tests" bson:"-"`
+	// PassRate is the ratio of the passed tests among the completed ones, it is computed on read
+	PassRate float64 `json:"pass_rate" bson:"-"`
+	// ParentID is the id of the run whose failed tests are retried by this run
+	ParentID string `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	// TestCaseIDs lists the testcases to replay, every testcase of the app is replayed when empty
+	TestCaseIDs []string `json:"test_case_ids,omitempty" bson:"test_case_ids,omitempty"`
+}
+
+// TestStats is the number of tests of a run in each status.
+type TestStats struct {
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Running int `json:"running"`
+	Pending int `json:"pending"`
+	// Total counts the tests in any status
+	Total int `json:"total"`
+	// LastStarted is the start of the newest test, 0 when the run has no test
+	LastStarted int64 `json:"last_started"`
+}
+
+// ReplayResult is the outcome of replaying a test run against a target.
+type ReplayResult struct {
+	RunID string `json:"run_id"`
+	// Failed is map[testID]reason for tests whose requests could not be replayed or compared.
+	Failed map[string]string `json:"failed"`
 }
 
 type TestRunStatus string
@@ -52,6 +96,7 @@ type Test struct {
 	RunID      string              `json:"run_id" bson:"run_id"`
 	TestCaseID string              `json:"testCaseID" bson:"test_case_id"`
 	URI        string              `json:"uri" bson:"uri"`
+	Method     models.Method       `json:"method" bson:"method,omitempty"`
 	Req        models.HttpReq      `json:"req" bson:"req"`
 	Dep        []models.Dependency `json:"dep" bson:"dep"`
 	Resp       models.HttpResp     `json:"http_resp" bson:"http_resp,omitempty"`
@@ -64,6 +109,8 @@ type Result struct {
 	HeadersResult []HeaderResult `json:"headers_result" bson:"headers_result"`
 	BodyResult    BodyResult     `json:"body_result" bson:"body_result"`
 	DepResult     []DepResult    `json:"dep_result" bson:"dep_result"`
+	// Latency compares the measured latency to the max latency of the testcase, in milliseconds
+	Latency *IntResult `json:"latency,omitempty" bson:"latency,omitempty"`
 }
 
 type DepResult struct {
@@ -83,6 +130,8 @@ type IntResult struct {
 	Normal   bool `json:"normal" bson:"normal"`
 	Expected int  `json:"expected" bson:"expected"`
 	Actual   int  `json:"actual" bson:"actual"`
+	// Class is set when only the classes of the values were compared, eg: 2xx
+	Class bool `json:"class,omitempty" bson:"class,omitempty"`
 }
 
 type HeaderResult struct {
@@ -101,6 +150,16 @@ type BodyResult struct {
 	Type     BodyType `json:"type" bson:"type"`
 	Expected string   `json:"expected" bson:"expected"`
 	Actual   string   `json:"actual" bson:"actual"`
+	// Diffs lists the differing fields of a mismatched JSON body, sorted by key
+	Diffs []BodyDiff `json:"diffs,omitempty" bson:"diffs,omitempty"`
+}
+
+// BodyDiff is a differing field of the body, keyed by its flattened path, eg: body.items.name.
+// The value is nil on the side where the field is missing.
+type BodyDiff struct {
+	Key      string   `json:"key" bson:"key"`
+	Expected []string `json:"expected" bson:"expected"`
+	Actual   []string `json:"actual" bson:"actual"`
 }
 
 type BodyType string
@@ -108,6 +167,7 @@ type BodyType string
 const (
 	BodyTypePlain BodyType = "PLAIN"
 	BodyTypeJSON  BodyType = "JSON"
+	BodyTypeXML   BodyType = "XML"
 )
 
 type TestStatus string
@@ -2,21 +2,65 @@ package pkg
 
 import (
 	"net/http"
+	"sort"
+	"strings"
 
 	"go.keploy.io/server/pkg/service/run"
 )
 
+// HeaderOptions relaxes the comparison of CompareHeadersWithOptions.
+type HeaderOptions struct {
+	// CaseInsensitive lists the headers whose values are compared ignoring the case,
+	// eg: Connection, where keep-alive and Keep-Alive are the same
+	CaseInsensitive []string
+	// Ignore lists the headers that are skipped like the noisy ones, eg: DefaultIgnoredHeaders
+	Ignore []string
+	// Unordered lists the headers whose values are compared as multisets, eg: Set-Cookie, where
+	// the order of the cookies isn't significant
+	Unordered []string
+	// EmptyAsMissing lists the headers for which an empty value, eg: "X-Foo:", and a missing header
+	// are equal
+	EmptyAsMissing []string
+}
+
+// DefaultIgnoredHeaders are the headers that change on every response, so the regression service
+// skips them unless they are removed from its ignored headers.
+var DefaultIgnoredHeaders = []string{"Date", "Content-Length", "Set-Cookie"}
+
 func CompareHeaders(h1 http.Header, h2 http.Header, res *[]run.HeaderResult, noise map[string]string) bool {
+	return CompareHeadersWithOptions(h1, h2, res, noise, HeaderOptions{})
+}
+
+// CompareHeadersWithOptions compares the headers like CompareHeaders, relaxed by opts.
+func CompareHeadersWithOptions(h1 http.Header, h2 http.Header, res *[]run.HeaderResult, noise map[string]string, opts HeaderOptions) bool {
 	match := true
 	_, isHeaderNoisy := noise["header"]
+	foldCase, ignore, unordered := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for _, k := range opts.CaseInsensitive {
+		foldCase[http.CanonicalHeaderKey(k)] = true
+	}
+	for _, k := range opts.Unordered {
+		unordered[http.CanonicalHeaderKey(k)] = true
+	}
+	emptyAsMissing := map[string]bool{}
+	for _, k := range opts.EmptyAsMissing {
+		emptyAsMissing[http.CanonicalHeaderKey(k)] = true
+	}
+	for _, k := range opts.Ignore {
+		ignore[http.CanonicalHeaderKey(k)] = true
+	}
 	for k, v := range h1 {
 		// Ignore go http router default headers
 		// if k == "Date" || k == "Content-Length" || k == "date" || k == "connection" {
 		// 	continue
 		// }
 		_, isNoisy := noise[k]
-		isNoisy = isNoisy || isHeaderNoisy
+		isNoisy = isNoisy || isHeaderNoisy || ignore[http.CanonicalHeaderKey(k)]
 		val, ok := h2[k]
+		if !isNoisy && emptyAsMissing[http.CanonicalHeaderKey(k)] && isEmptyHeader(v) && isEmptyHeader(val) {
+			// val is nil when the header is missing
+			isNoisy = true
+		}
 		if !isNoisy {
 			if !ok {
 				//fmt.Println("header not present", k)
@@ -55,8 +99,13 @@ func CompareHeaders(h1 http.Header, h2 http.Header, res *[]run.HeaderResult, noi
 				match = false
 				continue
 			}
-			for i, e := range v {
-				if val[i] != e {
+			fold := foldCase[http.CanonicalHeaderKey(k)]
+			exp, act := v, val
+			if unordered[http.CanonicalHeaderKey(k)] {
+				exp, act = sortedValues(v, fold), sortedValues(val, fold)
+			}
+			for i, e := range exp {
+				if act[i] != e && !(fold && strings.EqualFold(act[i], e)) {
 					//fmt.Println("value not same", k, v, val)
 					if checkKey(res, k) {
 						*res = append(*res, run.HeaderResult{
@@ -96,8 +145,9 @@ func CompareHeaders(h1 http.Header, h2 http.Header, res *[]run.HeaderResult, noi
 		// 	continue
 		// }
 		_, isNoisy := noise[k]
-		isNoisy = isNoisy || isHeaderNoisy
+		isNoisy = isNoisy || isHeaderNoisy || ignore[http.CanonicalHeaderKey(k)]
 		val, ok := h1[k]
+		isNoisy = isNoisy || (emptyAsMissing[http.CanonicalHeaderKey(k)] && isEmptyHeader(v) && isEmptyHeader(val))
 		if isNoisy && checkKey(res, k) {
 			*res = append(*res, run.HeaderResult{
 				Normal: true,
@@ -134,6 +184,29 @@ func CompareHeaders(h1 http.Header, h2 http.Header, res *[]run.HeaderResult, noi
 	return match
 }
 
+// isEmptyHeader reports whether the header has no value but empty ones.
+func isEmptyHeader(v []string) bool {
+	for _, s := range v {
+		if s != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedValues returns a sorted copy of the header values, ignoring the case when fold is set so
+// that the values equal but for the case are at the same position.
+func sortedValues(v []string, fold bool) []string {
+	res := append([]string{}, v...)
+	sort.SliceStable(res, func(i, j int) bool {
+		if fold {
+			return strings.ToLower(res[i]) < strings.ToLower(res[j])
+		}
+		return res[i] < res[j]
+	})
+	return res
+}
+
 func checkKey(res *[]run.HeaderResult, key string) bool {
 	for _, v := range *res {
 		if key == v.Expected.Key {
@@ -19,6 +19,7 @@ func TestCompareHeader(t *testing.T) {
 		actual    http.Header
 		hdrResult []run.HeaderResult
 		noise     map[string]string
+		opts      HeaderOptions
 		result    bool
 	}{
 		//keys and values matches
@@ -331,11 +332,333 @@ func TestCompareHeader(t *testing.T) {
 			noise:  map[string]string{"host": "host"},
 			result: false,
 		},
+		//values differing in case, compared case sensitively by default
+		{
+			exp: http.Header{
+				"Connection": {"keep-alive"},
+			},
+			actual: http.Header{
+				"Connection": {"Keep-Alive"},
+			},
+			hdrResult: []run.HeaderResult{
+				{
+					Normal: false,
+					Expected: run.Header{
+						Key:   "Connection",
+						Value: []string{"keep-alive"},
+					},
+					Actual: run.Header{
+						Key:   "Connection",
+						Value: []string{"Keep-Alive"},
+					},
+				},
+			},
+			noise:  map[string]string{},
+			result: false,
+		},
+		//values differing in case of a case insensitive header, the key is matched in any case
+		{
+			exp: http.Header{
+				"Connection": {"keep-alive"},
+				"Vary":       {"Accept", "origin"},
+			},
+			actual: http.Header{
+				"Connection": {"Keep-Alive"},
+				"Vary":       {"accept", "Origin"},
+			},
+			hdrResult: []run.HeaderResult{
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "Connection",
+						Value: []string{"keep-alive"},
+					},
+					Actual: run.Header{
+						Key:   "Connection",
+						Value: []string{"Keep-Alive"},
+					},
+				},
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "Vary",
+						Value: []string{"Accept", "origin"},
+					},
+					Actual: run.Header{
+						Key:   "Vary",
+						Value: []string{"accept", "Origin"},
+					},
+				},
+			},
+			noise:  map[string]string{},
+			opts:   HeaderOptions{CaseInsensitive: []string{"connection", "VARY"}},
+			result: true,
+		},
+		//only the configured headers are compared ignoring the case
+		{
+			exp: http.Header{
+				"Connection":   {"keep-alive"},
+				"Content-Type": {"application/json"},
+			},
+			actual: http.Header{
+				"Connection":   {"Keep-Alive"},
+				"Content-Type": {"Application/JSON"},
+			},
+			hdrResult: []run.HeaderResult{
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "Connection",
+						Value: []string{"keep-alive"},
+					},
+					Actual: run.Header{
+						Key:   "Connection",
+						Value: []string{"Keep-Alive"},
+					},
+				},
+				{
+					Normal: false,
+					Expected: run.Header{
+						Key:   "Content-Type",
+						Value: []string{"application/json"},
+					},
+					Actual: run.Header{
+						Key:   "Content-Type",
+						Value: []string{"Application/JSON"},
+					},
+				},
+			},
+			noise:  map[string]string{},
+			opts:   HeaderOptions{CaseInsensitive: []string{"Connection"}},
+			result: false,
+		},
+		//ignored headers are skipped even if they differ or are missing
+		{
+			exp: http.Header{
+				"Date":           {"Mon, 10 Oct 2022 10:00:00 GMT"},
+				"Content-Length": {"26"},
+				"id":             {"1234"},
+			},
+			actual: http.Header{
+				"Date":       {"Tue, 11 Oct 2022 10:00:00 GMT"},
+				"Set-Cookie": {"session=1; Expires=Wed, 12 Oct 2022 10:00:00 GMT"},
+				"id":         {"1234"},
+			},
+			hdrResult: []run.HeaderResult{
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "Date",
+						Value: []string{"Mon, 10 Oct 2022 10:00:00 GMT"},
+					},
+					Actual: run.Header{
+						Key:   "Date",
+						Value: []string{"Tue, 11 Oct 2022 10:00:00 GMT"},
+					},
+				},
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "Content-Length",
+						Value: []string{"26"},
+					},
+					Actual: run.Header{
+						Key:   "Content-Length",
+						Value: nil,
+					},
+				},
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "Set-Cookie",
+						Value: nil,
+					},
+					Actual: run.Header{
+						Key:   "Set-Cookie",
+						Value: []string{"session=1; Expires=Wed, 12 Oct 2022 10:00:00 GMT"},
+					},
+				},
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "id",
+						Value: []string{"1234"},
+					},
+					Actual: run.Header{
+						Key:   "id",
+						Value: []string{"1234"},
+					},
+				},
+			},
+			noise:  map[string]string{},
+			opts:   HeaderOptions{Ignore: DefaultIgnoredHeaders},
+			result: true,
+		},
+		//the values of the unordered headers are compared as multisets
+		{
+			exp: http.Header{
+				"Set-Cookie": {"a=1", "b=2", "a=1"},
+				"Vary":       {"Origin", "Accept"},
+			},
+			actual: http.Header{
+				"Set-Cookie": {"a=1", "a=1", "b=2"},
+				"Vary":       {"accept", "origin"},
+			},
+			hdrResult: []run.HeaderResult{
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "Set-Cookie",
+						Value: []string{"a=1", "b=2", "a=1"},
+					},
+					Actual: run.Header{
+						Key:   "Set-Cookie",
+						Value: []string{"a=1", "a=1", "b=2"},
+					},
+				},
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "Vary",
+						Value: []string{"Origin", "Accept"},
+					},
+					Actual: run.Header{
+						Key:   "Vary",
+						Value: []string{"accept", "origin"},
+					},
+				},
+			},
+			noise:  map[string]string{},
+			opts:   HeaderOptions{Unordered: []string{"set-cookie", "Vary"}, CaseInsensitive: []string{"Vary"}},
+			result: true,
+		},
+		//a multiset needs the same number of every value
+		{
+			exp: http.Header{
+				"Set-Cookie": {"a=1", "b=2", "a=1"},
+			},
+			actual: http.Header{
+				"Set-Cookie": {"b=2", "a=1", "b=2"},
+			},
+			hdrResult: []run.HeaderResult{
+				{
+					Normal: false,
+					Expected: run.Header{
+						Key:   "Set-Cookie",
+						Value: []string{"a=1", "b=2", "a=1"},
+					},
+					Actual: run.Header{
+						Key:   "Set-Cookie",
+						Value: []string{"b=2", "a=1", "b=2"},
+					},
+				},
+			},
+			noise:  map[string]string{},
+			opts:   HeaderOptions{Unordered: []string{"Set-Cookie"}},
+			result: false,
+		},
+		//an empty header equals a missing one for the configured headers only
+		{
+			exp: http.Header{
+				"X-Foo": {""},
+				"X-Bar": {},
+				"X-Baz": {""},
+			},
+			actual: http.Header{
+				"X-Bar": {""},
+				"X-Qux": {""},
+				"X-Zip": {""},
+			},
+			hdrResult: []run.HeaderResult{
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "X-Foo",
+						Value: []string{""},
+					},
+					Actual: run.Header{
+						Key:   "X-Foo",
+						Value: nil,
+					},
+				},
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "X-Bar",
+						Value: []string{},
+					},
+					Actual: run.Header{
+						Key:   "X-Bar",
+						Value: []string{""},
+					},
+				},
+				{
+					Normal: false,
+					Expected: run.Header{
+						Key:   "X-Baz",
+						Value: []string{""},
+					},
+					Actual: run.Header{
+						Key:   "X-Baz",
+						Value: nil,
+					},
+				},
+				{
+					Normal: true,
+					Expected: run.Header{
+						Key:   "X-Qux",
+						Value: nil,
+					},
+					Actual: run.Header{
+						Key:   "X-Qux",
+						Value: []string{""},
+					},
+				},
+				{
+					Normal: false,
+					Expected: run.Header{
+						Key:   "X-Zip",
+						Value: nil,
+					},
+					Actual: run.Header{
+						Key:   "X-Zip",
+						Value: []string{""},
+					},
+				},
+			},
+			noise:  map[string]string{},
+			opts:   HeaderOptions{EmptyAsMissing: []string{"x-foo", "X-Bar", "X-Qux"}},
+			result: false,
+		},
+		//the values are compared positionally by default
+		{
+			exp: http.Header{
+				"Set-Cookie": {"a=1", "b=2"},
+			},
+			actual: http.Header{
+				"Set-Cookie": {"b=2", "a=1"},
+			},
+			hdrResult: []run.HeaderResult{
+				{
+					Normal: false,
+					Expected: run.Header{
+						Key:   "Set-Cookie",
+						Value: []string{"a=1", "b=2"},
+					},
+					Actual: run.Header{
+						Key:   "Set-Cookie",
+						Value: []string{"b=2", "a=1"},
+					},
+				},
+			},
+			noise:  map[string]string{},
+			result: false,
+		},
 	} {
 		logger, _ := zap.NewProduction()
 		defer logger.Sync()
 		hdrResult := []run.HeaderResult{}
-		res := CompareHeaders(tt.exp, tt.actual, &hdrResult, tt.noise)
+		res := CompareHeadersWithOptions(tt.exp, tt.actual, &hdrResult, tt.noise, tt.opts)
 		if res != tt.result {
 			t.Fatal(tt.exp, tt.actual, "THIS IS EXP", tt.hdrResult, " \n THIS IS ACT", hdrResult)
 		}
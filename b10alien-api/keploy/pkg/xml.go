@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// IsXML returns true if s is a well-formed XML document with a single root element. The tokens
+// are only scanned, so any depth is accepted without recursing.
+func IsXML(s string) bool {
+	if !strings.HasPrefix(strings.TrimSpace(s), "<") {
+		return false
+	}
+	dec := xml.NewDecoder(strings.NewReader(s))
+	roots, depth := 0, 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return roots == 1
+		}
+		if err != nil {
+			return false
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 0 && strings.TrimSpace(string(t)) != "" {
+				return false
+			}
+		}
+	}
+}
+
+// MatchXML is Match for XML bodies. The documents are compared structurally, so the order of
+// attributes and the whitespace between elements don't matter. Noise fields are dotted paths of
+// element names starting at the root element, attributes are prefixed with "@" (eg: alien.@id).
+// ErrMaxDepth is returned for the documents nested deeper than maxDepth, zero means DefaultMaxDepth.
+func MatchXML(exp, act string, noise []string, maxDepth int, log *zap.Logger) (bool, error) {
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	noiseMap := convertToMap(noise)
+	expected, err := convertXml(exp, maxDepth)
+	if err != nil {
+		log.Error("cannot convert xml string into xml tree", zap.Error(err))
+		return false, err
+	}
+	actual, err := convertXml(act, maxDepth)
+	if err != nil {
+		log.Error("cannot convert xml string into xml tree", zap.Error(err))
+		return false, err
+	}
+	if reflect.TypeOf(expected) != reflect.TypeOf(actual) {
+		return false, nil
+	}
+	tmp := mapClone(noiseMap)
+	expected = removeNoisy(expected, tmp)
+
+	tmp = mapClone(noiseMap)
+	actual = removeNoisy(actual, tmp)
+	return jsonMatch(expected, actual)
+}
+
+// convertXml returns the XML document as a tree in the shape of an unmarshalled JSON object,
+// so that it can be compared with jsonMatch. The root is {rootName: element} where an element
+// is its text if it has neither attributes nor children, and otherwise a map of its attributes
+// ("@name"), children (by name, a slice when repeated) and text ("#text"). ErrMaxDepth is returned
+// when the tree is nested deeper than max, the same depth as CheckDepth.
+func convertXml(s string, max int) (interface{}, error) {
+	dec := xml.NewDecoder(strings.NewReader(s))
+	var root map[string]interface{}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root != nil {
+				return nil, errors.New("xml document has more than one root element")
+			}
+			el, err := convertXmlElement(dec, t, max-1)
+			if err != nil {
+				return nil, err
+			}
+			root = map[string]interface{}{xmlName(t.Name): el}
+		case xml.CharData:
+			if strings.TrimSpace(string(t)) != "" {
+				return nil, errors.New("xml document has text outside of the root element")
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("xml document has no root element")
+	}
+	// the repeated children are slices, which nest the tree deeper than the elements
+	if err := CheckDepth(root, max); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// convertXmlElement converts the element started by start, reading its tokens up to the end element.
+// The element may nest max levels of children, ErrMaxDepth is returned for the deeper ones.
+func convertXmlElement(dec *xml.Decoder, start xml.StartElement, max int) (interface{}, error) {
+	if max < 0 {
+		return nil, ErrMaxDepth
+	}
+	el := map[string]interface{}{}
+	for _, a := range start.Attr {
+		el["@"+xmlName(a.Name)] = a.Value
+	}
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := convertXmlElement(dec, t, max-1)
+			if err != nil {
+				return nil, err
+			}
+			name := xmlName(t.Name)
+			switch prev := el[name].(type) {
+			case nil:
+				el[name] = child
+			case []interface{}:
+				el[name] = append(prev, child)
+			default:
+				el[name] = []interface{}{prev, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(el) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				el["#text"] = s
+			}
+			return el, nil
+		}
+	}
+}
+
+// xmlName returns the local name, prefixed with the namespace if any.
+func xmlName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
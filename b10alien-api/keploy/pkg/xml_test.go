@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestXmlDiff(t *testing.T) {
+	for _, tt := range []struct {
+		exp    string
+		actual string
+		noise  []string
+		result bool
+	}{
+		// reordered attributes
+		{
+			exp:    `<alien id="1" name="Alien-X" power="90000"/>`,
+			actual: `<alien power="90000" name="Alien-X" id="1"></alien>`,
+			noise:  []string{},
+			result: true,
+		},
+		// whitespace between the elements and around the text
+		{
+			exp:    `<?xml version="1.0"?><aliens><alien id="3"><name>Xlr8</name></alien></aliens>`,
+			actual: "<aliens>\n  <alien id=\"3\">\n    <name> Xlr8 </name>\n  </alien>\n</aliens>\n",
+			noise:  []string{},
+			result: true,
+		},
+		{
+			exp:    `<alien id="1" name="Alien-X"/>`,
+			actual: `<alien name="Alien-X" id="2"/>`,
+			noise:  []string{},
+			result: false,
+		},
+		{
+			exp:    `<alien><name>Alien-X</name></alien>`,
+			actual: `<alien><name>Alien-Y</name></alien>`,
+			noise:  []string{},
+			result: false,
+		},
+		{
+			exp:    `<alien><name>Alien-X</name></alien>`,
+			actual: `<alien><name>Alien-X</name><power>1</power></alien>`,
+			noise:  []string{},
+			result: false,
+		},
+		// noisy attributes and elements
+		{
+			exp:    `<alien id="1" updated="100"><name>Alien-X</name><seen>10:00</seen></alien>`,
+			actual: `<alien updated="200" id="1"><seen>12:21</seen><name>Alien-X</name></alien>`,
+			noise:  []string{"alien.@updated", "alien.seen"},
+			result: true,
+		},
+		// noise on the elements of a repeated element
+		{
+			exp:    `<aliens><alien id="1" ts="1"/><alien id="2" ts="2"/></aliens>`,
+			actual: `<aliens><alien ts="3" id="1"/><alien ts="4" id="2"/></aliens>`,
+			noise:  []string{"aliens.alien.@ts"},
+			result: true,
+		},
+		{
+			exp:    `<aliens><alien id="1" ts="1"/><alien id="2" ts="2"/></aliens>`,
+			actual: `<aliens><alien ts="3" id="1"/><alien ts="4" id="5"/></aliens>`,
+			noise:  []string{"aliens.alien.@ts"},
+			result: false,
+		},
+	} {
+		logger, _ := zap.NewProduction()
+		defer logger.Sync()
+		res, err := MatchXML(tt.exp, tt.actual, tt.noise, 0, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != tt.result {
+			t.Fatal(tt.exp, tt.actual, "THIS IS EXP", tt.result, " \n THIS IS ACT", res)
+		}
+	}
+}
+
+// nestedXml returns a document of depth nested elements, as deep as nested(depth) in JSON.
+func nestedXml(depth int) string {
+	return strings.Repeat("<a>", depth) + "1" + strings.Repeat("</a>", depth)
+}
+
+func TestXmlMaxDepth(t *testing.T) {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	for _, tt := range []struct {
+		depth int
+		max   int
+		err   error
+	}{
+		{depth: 100, max: 0},
+		{depth: 101, max: 0, err: ErrMaxDepth},
+		{depth: 10, max: 10},
+		{depth: 11, max: 10, err: ErrMaxDepth},
+		// far deeper than the stack would allow by recursion
+		{depth: 100000, max: 0, err: ErrMaxDepth},
+	} {
+		doc := nestedXml(tt.depth)
+		if !IsXML(doc) {
+			t.Fatal("THIS IS EXP", true, " \n THIS IS ACT", false, tt.depth)
+		}
+		ok, err := MatchXML(doc, doc, nil, tt.max, logger)
+		if !errors.Is(err, tt.err) || (tt.err == nil && !ok) {
+			t.Fatal("THIS IS EXP", tt.err, " \n THIS IS ACT", err, tt.depth)
+		}
+	}
+	// the repeated children nest the tree one level deeper, eg: {a: [{a: "1"}, {a: "2"}]}
+	if _, err := MatchXML(`<a><a><a>1</a><a>2</a></a></a>`, `<a/>`, nil, 2, logger); !errors.Is(err, ErrMaxDepth) {
+		t.Fatal("THIS IS EXP", ErrMaxDepth, " \n THIS IS ACT", err)
+	}
+	// the actual document is checked too
+	if _, err := MatchXML(nestedXml(1), nestedXml(200), nil, 0, logger); !errors.Is(err, ErrMaxDepth) {
+		t.Fatal("THIS IS EXP", ErrMaxDepth, " \n THIS IS ACT", err)
+	}
+}
+
+func TestIsXML(t *testing.T) {
+	for _, tt := range []struct {
+		body  string
+		isXML bool
+	}{
+		{body: `<alien id="1"/>`, isXML: true},
+		{body: "\n<?xml version=\"1.0\"?>\n<aliens></aliens>\n", isXML: true},
+		{body: `<alien>`, isXML: false},
+		{body: `<a/><b/>`, isXML: false},
+		{body: `{"id": 1}`, isXML: false},
+		{body: `Item Deleted`, isXML: false},
+		{body: ``, isXML: false},
+	} {
+		if act := IsXML(tt.body); act != tt.isXML {
+			t.Fatal(tt.body, "THIS IS EXP", tt.isXML, " \n THIS IS ACT", act)
+		}
+	}
+}
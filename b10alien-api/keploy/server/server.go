@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"log"
 	"math/rand"
 	"net"
@@ -43,6 +44,39 @@ type config struct {
 	APIKey          string `envconfig:"API_KEY"`
 	EnableDeDup     bool   `envconfig:"ENABLE_DEDUP" default:"false"`
 	EnableTelemetry bool   `envconfig:"ENABLE_TELEMETRY" default:"true"`
+	SortBodyKeys    bool   `envconfig:"SORT_BODY_KEYS" default:"false"`
+	IndexArrays     bool   `envconfig:"INDEX_ARRAYS" default:"false"`
+	// MaxDedupBodySize is the size in bytes above which the deduplication hashes a request body
+	MaxDedupBodySize int `envconfig:"MAX_DEDUP_BODY_SIZE" default:"8388608"`
+	// MaxJSONDepth is the deepest nesting of the compared and deduplicated JSON bodies, and of the compared XML bodies
+	MaxJSONDepth int `envconfig:"MAX_JSON_DEPTH" default:"100"`
+	// CaseInsensitiveHeaders is a comma separated list of the headers compared ignoring the case
+	CaseInsensitiveHeaders []string `envconfig:"CASE_INSENSITIVE_HEADERS"`
+	// IgnoredHeaders is a comma separated list of the headers skipped in every testcase
+	IgnoredHeaders []string `envconfig:"IGNORED_HEADERS" default:"Date,Content-Length,Set-Cookie"`
+	// UnorderedHeaders is a comma separated list of the headers whose values are compared in any order
+	UnorderedHeaders []string `envconfig:"UNORDERED_HEADERS"`
+	// EmptyAsMissingHeaders is a comma separated list of the headers whose empty value equals a missing header
+	EmptyAsMissingHeaders []string `envconfig:"EMPTY_AS_MISSING_HEADERS"`
+	// FloatAbsTolerance and FloatRelTolerance loosen the comparison of the numbers in the bodies
+	FloatAbsTolerance float64 `envconfig:"FLOAT_ABS_TOLERANCE" default:"0"`
+	FloatRelTolerance float64 `envconfig:"FLOAT_REL_TOLERANCE" default:"0"`
+	// MongoTimeout bounds every query on the test runs and their tests
+	MongoTimeout time.Duration `envconfig:"MONGO_TIMEOUT" default:"5s"`
+	// MongoCleanupTimeout bounds each step of the deletion of the old test runs
+	MongoCleanupTimeout time.Duration `envconfig:"MONGO_CLEANUP_TIMEOUT" default:"5m"`
+	// MongoConnectAttempts bounds the connection attempts at startup, eg: while mongo is starting
+	MongoConnectAttempts int `envconfig:"MONGO_CONNECT_ATTEMPTS" default:"5"`
+	// MongoConnectMaxBackoff caps the exponential wait between the connection attempts
+	MongoConnectMaxBackoff time.Duration `envconfig:"MONGO_CONNECT_MAX_BACKOFF" default:"30s"`
+	// StaleRunTimeout is the time after which a running test run without any new test is failed
+	StaleRunTimeout time.Duration `envconfig:"STALE_RUN_TIMEOUT" default:"5m"`
+	// AnchorMinSamples and AnchorMaxUniqueRatio tune the variance heuristic of the deduplication
+	AnchorMinSamples     int     `envconfig:"ANCHOR_MIN_SAMPLES" default:"20"`
+	AnchorMaxUniqueRatio float64 `envconfig:"ANCHOR_MAX_UNIQUE_RATIO" default:"0.40"`
+	// PersistDedupCache stores the deduplication cache in the DedupCacheTable so that it survives restarts
+	PersistDedupCache bool   `envconfig:"PERSIST_DEDUP_CACHE" default:"false"`
+	DedupCacheTable   string `envconfig:"DEDUP_CACHE_TABLE" default:"dedup-cache"`
 }
 
 func Server() *chi.Mux {
@@ -61,7 +95,7 @@ func Server() *chi.Mux {
 		logger.Error("failed to read/process configuration", zap.Error(err))
 	}
 
-	cl, err := mgo.New(conf.MongoURI)
+	cl, err := mgo.New(conf.MongoURI, conf.MongoConnectAttempts, conf.MongoConnectMaxBackoff, logger)
 	if err != nil {
 		logger.Fatal("failed to create mgo db client", zap.Error(err))
 	}
@@ -71,16 +105,41 @@ func Server() *chi.Mux {
 	tdb := mgo.NewTestCase(kmongo.NewCollection(db.Collection(conf.TestCaseTable)), logger)
 
 	rdb := mgo.NewRun(kmongo.NewCollection(db.Collection(conf.TestRunTable)), kmongo.NewCollection(db.Collection(conf.TestTable)), logger)
+	rdb.Timeout = conf.MongoTimeout
+	rdb.CleanupTimeout = conf.MongoCleanupTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err = rdb.EnsureIndexes(ctx)
+	cancel()
+	if err != nil {
+		logger.Error("failed to create the indexes of the test runs", zap.Error(err))
+	}
 
-	enabled := conf.EnableTelemetry
-	analyticsConfig := telemetry.NewTelemetry(mgo.NewTelemetryDB(db, conf.TelemetryTable, enabled, logger), enabled, keploy.GetMode() == keploy.MODE_OFF, logger)
+	enabled := conf.EnableTelemetry && !telemetry.Disabled()
+	// KEPLOY_TELEMETRY=off replaces the telemetry by a no-op one, so that nothing is sent
+	analyticsConfig := telemetry.FromEnv(telemetry.NewTelemetry(mgo.NewTelemetryDB(db, conf.TelemetryTable, enabled, logger), enabled, keploy.GetMode() == keploy.MODE_OFF, logger))
 
 	client := http.Client{
 		Transport: khttpclient.NewInterceptor(http.DefaultTransport),
 	}
 
 	regSrv := regression2.New(tdb, rdb, logger, conf.EnableDeDup, analyticsConfig, client)
-	runSrv := run.New(rdb, tdb, logger, analyticsConfig, client)
+	regSrv.SortBodyKeys = conf.SortBodyKeys
+	regSrv.AnchorMinSamples = conf.AnchorMinSamples
+	regSrv.AnchorMaxUniqueRatio = conf.AnchorMaxUniqueRatio
+	regSrv.IndexArrays = conf.IndexArrays
+	regSrv.MaxDedupBodySize = conf.MaxDedupBodySize
+	regSrv.MaxJSONDepth = conf.MaxJSONDepth
+	regSrv.FloatAbsTolerance = conf.FloatAbsTolerance
+	regSrv.FloatRelTolerance = conf.FloatRelTolerance
+	regSrv.CaseInsensitiveHeaders = conf.CaseInsensitiveHeaders
+	regSrv.IgnoredHeaders = conf.IgnoredHeaders
+	regSrv.UnorderedHeaders = conf.UnorderedHeaders
+	regSrv.EmptyAsMissingHeaders = conf.EmptyAsMissingHeaders
+	if conf.PersistDedupCache {
+		regSrv.CacheDB = mgo.NewDedupCache(kmongo.NewCollection(db.Collection(conf.DedupCacheTable)), logger)
+	}
+	runSrv := run.New(rdb, tdb, regSrv, logger, analyticsConfig, client)
+	runSrv.StaleTimeout = conf.StaleRunTimeout
 
 	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: graph.NewResolver(logger, runSrv, regSrv)}))
 
@@ -1,19 +1,104 @@
 package main
 
 import (
+    "context"
+    "crypto/sha1"
+    "encoding/csv"
+    "encoding/json"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "math/rand"
     "net/http"
+    "net/url"
+    "os"
+    "os/signal"
+    "reflect"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
 "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
     "github.com/keploy/go-sdk/integrations/kgin/v1" // NEW LINE
+    "github.com/keploy/go-sdk/integrations/kmongo"
     "github.com/keploy/go-sdk/keploy" // NEW LINE
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.uber.org/zap"
 )
 
 type b10alien struct {
-	ID      string `json:"id"`
-    Name    string `json:"name"`
-    Power   int64  `json:"power"`
-    Special string `json:"special"`
+	ID      string `json:"id" bson:"_id" xml:"id"`
+    // The schema tags are the constraints published on /b10aliens/schema, see validateB10alien
+    Name    string `json:"name" bson:"name" xml:"name" schema:"required"`
+    Power   int64  `json:"power" bson:"power" xml:"power" schema:"minimum=0"`
+    Special string `json:"special" bson:"special" xml:"special" schema:"required"`
+    // CreatedAt and UpdatedAt are unix millis set by the server, the client values are ignored
+    CreatedAt int64 `json:"created_at" bson:"created_at" xml:"created_at" schema:"readonly"`
+    UpdatedAt int64 `json:"updated_at" bson:"updated_at" xml:"updated_at" schema:"readonly"`
+    // Version is incremented on every write, the edits must send the current one
+    Version int64 `json:"version" bson:"version" xml:"version"`
+    // Deleted marks the soft deleted aliens, they are hidden unless asked for
+    Deleted bool `json:"-" bson:"deleted" xml:"-"`
 }
 
+// b10alienList is the XML root of the alien lists, XML needs a single root element
+type b10alienList struct {
+    XMLName xml.Name   `xml:"b10aliens"`
+    Aliens  []b10alien `xml:"b10alien"`
+}
+
+// b10alienFieldList is b10alienList with only the selected fields of the aliens, by their xml names
+type b10alienFieldList struct {
+    Aliens []b10alien
+    Fields map[string]bool
+}
+
+func (l b10alienFieldList) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    start = xml.StartElement{Name: xml.Name{Local: "b10aliens"}}
+    if err := e.EncodeToken(start); err != nil {
+        return err
+    }
+    t := reflect.TypeOf(b10alien{})
+    for _, alien := range l.Aliens {
+        el := xml.StartElement{Name: xml.Name{Local: "b10alien"}}
+        if err := e.EncodeToken(el); err != nil {
+            return err
+        }
+        v := reflect.ValueOf(alien)
+        for i := 0; i < t.NumField(); i++ {
+            name, _, _ := strings.Cut(t.Field(i).Tag.Get("xml"), ",")
+            if name == "-" || !l.Fields[name] {
+                continue
+            }
+            if err := e.EncodeElement(v.Field(i).Interface(), xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+                return err
+            }
+        }
+        if err := e.EncodeToken(el.End()); err != nil {
+            return err
+        }
+    }
+    return e.EncodeToken(start.End())
+}
+
+// logger is shared by the handlers and middlewares, it is set up in main
+var logger = zap.NewNop()
+
+// appMetrics is the prometheus collector exposed on /metrics
+var appMetrics = newMetrics()
+
+// aliens is the mongo store of the aliens, it is set up in main
+var aliens *AlienDB
+
+// b10aliensMu serializes the writes, it must be held for the whole read-modify-write on the store
+var b10aliensMu sync.Mutex
+
+// b10aliens is the seed data inserted into an empty collection on startup
 var b10aliens = []b10alien{
 	{ID: "1", Name: "Alien-X", Power: 90000, Special: "intelligence, power, speed, hax"},
 	{ID: "2", Name: "Swamp-Fire", Power: 2000, Special: "fire, plant, invulnerabilityxl"},
@@ -23,32 +108,548 @@ var b10aliens = []b10alien{
 }
 
 func home(c *gin.Context) {
-    c.JSON(http.StatusOK, gin.H{ // H is a shortcut for map[string]interface{}
-        "instructions": "Add '/b10aliens' to the link",
+    respond(c, http.StatusOK, gin.H{ // H is a shortcut for map[string]interface{}
+        "instructions": "Add '/v1/b10aliens' to the link",
     })
 }
 
 func getB10aliens(c *gin.Context) {
+    filter, err := aliensFilter(c)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, err.Error())
+        return
+    }
+    
     // Printing all the Aliens available in the data
-    c.JSON(http.StatusOK, b10aliens)
+    result, err := aliens.GetAll(c.Request.Context(), filter)
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    
+    c.Header("X-Total-Count", strconv.Itoa(len(result)))
+    
+    // Optional ordering ("?sort=power_desc"), unknown values keep the insertion order
+    if less := alienOrder(c.Query("sort")); less != nil {
+        sort.Slice(result, func(i, j int) bool {
+            return less(result[i], result[j])
+        })
+    }
+    
+    // Optional field selection ("?fields=id,name") for the JSON and XML lists, unknown fields are ignored
+    if fields := c.Query("fields"); fields != "" {
+        keep := fieldSet(strings.Split(fields, ","))
+        selected, err := selectFields(result, keep)
+        if err != nil {
+            internalError(c, err)
+            return
+        }
+        negotiate(c, http.StatusOK, selected, b10alienFieldList{Aliens: result, Fields: keep})
+        return
+    }
+    negotiate(c, http.StatusOK, result, b10alienList{Aliens: result})
+}
+
+// headB10aliens answers with the headers of getB10aliens, counting in the store instead of fetching the list
+func headB10aliens(c *gin.Context) {
+    filter, err := aliensFilter(c)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, err.Error())
+        return
+    }
+    count, err := aliens.Count(c.Request.Context(), filter)
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    c.Header("X-Total-Count", strconv.FormatInt(count, 10))
+    c.Header("Content-Type", gin.MIMEJSON+"; charset=utf-8")
+    c.Writer.Header().Add("Vary", "Accept")
+    c.Status(http.StatusOK)
+}
+
+func getB10alien(c *gin.Context) {
+    alien, err := aliens.Get(c.Request.Context(), c.Param("id"))
+    if errors.Is(err, ErrAlienNotFound) || alien.Deleted {
+        respondError(c, http.StatusNotFound, "alien not found")
+        return
+    }
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    
+    // Polling clients send back the ETag and get a 304 as long as the alien is unchanged
+    etag, err := alienETag(alien)
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    c.Header("ETag", etag)
+    if etagMatches(c.GetHeader("If-None-Match"), etag) {
+        c.Status(http.StatusNotModified)
+        return
+    }
+    negotiate(c, http.StatusOK, alien, alien)
+}
+
+// alienETag returns a weak ETag of the alien, changing whenever any of its fields change
+func alienETag(alien b10alien) (string, error) {
+    b, err := json.Marshal(alien)
+    if err != nil {
+        return "", err
+    }
+    return fmt.Sprintf(`W/"%x"`, sha1.Sum(b)), nil
+}
+
+// etagMatches reports whether the If-None-Match header matches the ETag, using the weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+    for _, tag := range strings.Split(ifNoneMatch, ",") {
+        tag = strings.TrimSpace(tag)
+        if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+            return true
+        }
+    }
+    return false
+}
+
+func strongestB10alien(c *gin.Context) {
+    firstB10alien(c, bson.D{{Key: "power", Value: -1}, {Key: "_id", Value: 1}})
+}
+
+func weakestB10alien(c *gin.Context) {
+    firstB10alien(c, bson.D{{Key: "power", Value: 1}, {Key: "_id", Value: 1}})
+}
+
+// firstB10alien responds with the first of the listed aliens in the given order, the ties are broken by the lowest id
+func firstB10alien(c *gin.Context, order bson.D) {
+    filter, err := aliensFilter(c)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, err.Error())
+        return
+    }
+    alien, err := aliens.First(c.Request.Context(), filter, order)
+    if errors.Is(err, ErrAlienNotFound) {
+        respondError(c, http.StatusNotFound, "no aliens found")
+        return
+    }
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    negotiate(c, http.StatusOK, alien, alien)
+}
+
+func randomB10alien(c *gin.Context) {
+    filter, err := aliensFilter(c)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, err.Error())
+        return
+    }
+    result, err := aliens.GetAll(c.Request.Context(), filter)
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    if len(result) == 0 {
+        respondError(c, http.StatusNotFound, "no aliens found")
+        return
+    }
+    // Every matching alien has the same chance to be picked
+    alien := result[rand.Intn(len(result))]
+    negotiate(c, http.StatusOK, alien, alien)
+}
+
+func countB10aliens(c *gin.Context) {
+    filter, err := aliensFilter(c)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, err.Error())
+        return
+    }
+    
+    // Counting in the store instead of fetching the list
+    count, err := aliens.Count(c.Request.Context(), filter)
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    respond(c, http.StatusOK, gin.H{
+        "count": count,
+    })
+}
+
+func searchB10aliens(c *gin.Context) {
+    // Any of the comma separated terms ("?special=fire,speed") must be part of the special, ignoring the case
+    var terms []bson.M
+    for _, term := range strings.Split(c.Query("special"), ",") {
+        term = strings.TrimSpace(term)
+        if term == "" {
+            continue
+        }
+        terms = append(terms, bson.M{"special": bson.M{"$regex": regexp.QuoteMeta(term), "$options": "i"}})
+    }
+    if len(terms) == 0 {
+        respondError(c, http.StatusBadRequest, "special is required")
+        return
+    }
+    
+    result, err := aliens.GetAll(c.Request.Context(), bson.M{"$or": terms, "deleted": bson.M{"$ne": true}})
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    respond(c, http.StatusOK, result)
+}
+
+func exportB10aliens(c *gin.Context) {
+    filter, err := aliensFilter(c)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, err.Error())
+        return
+    }
+    result, err := aliens.GetAll(c.Request.Context(), filter)
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    
+    // The csv writer quotes the fields containing commas, like most of the specials
+    c.Header("Content-Type", "text/csv; charset=utf-8")
+    c.Header("Content-Disposition", `attachment; filename="b10aliens.csv"`)
+    c.Status(http.StatusOK)
+    w := csv.NewWriter(c.Writer)
+    w.Write([]string{"id", "name", "power", "special"})
+    for _, alien := range result {
+        w.Write([]string{alien.ID, alien.Name, strconv.FormatInt(alien.Power, 10), alien.Special})
+    }
+    w.Flush()
+    if err := w.Error(); err != nil {
+        ctxLogger(c).Error("failed to write the csv export", zap.Error(err))
+    }
+}
+
+// fieldSet returns the set of the selected field names
+func fieldSet(fields []string) map[string]bool {
+    keep := map[string]bool{}
+    for _, f := range fields {
+        keep[strings.TrimSpace(f)] = true
+    }
+    return keep
+}
+
+// selectFields returns the aliens as JSON objects with only the given fields
+func selectFields(list []b10alien, keep map[string]bool) ([]map[string]interface{}, error) {
+    res := make([]map[string]interface{}, 0, len(list))
+    for _, alien := range list {
+        b, err := json.Marshal(alien)
+        if err != nil {
+            return nil, err
+        }
+        var m map[string]interface{}
+        if err := json.Unmarshal(b, &m); err != nil {
+            return nil, err
+        }
+        for k := range m {
+            if !keep[k] {
+                delete(m, k)
+            }
+        }
+        res = append(res, m)
+    }
+    return res, nil
+}
+
+// aliensFilter builds the store filter from the list query parameters
+func aliensFilter(c *gin.Context) (bson.M, error) {
+    filter := bson.M{}
+    
+    // The soft deleted aliens are only listed with "?include_deleted=true"
+    if c.Query("include_deleted") != "true" {
+        filter["deleted"] = bson.M{"$ne": true}
+    }
+    
+    // Optional inclusive range ("?min_power=1500&max_power=2000"), a missing bound is unbounded
+    power := bson.M{}
+    if minPowerStr, ok := c.GetQuery("min_power"); ok {
+        minPower, err := strconv.ParseInt(minPowerStr, 10, 64)
+        if err != nil {
+            return nil, errors.New("min_power must be a number")
+        }
+        power["$gte"] = minPower
+    }
+    if maxPowerStr, ok := c.GetQuery("max_power"); ok {
+        maxPower, err := strconv.ParseInt(maxPowerStr, 10, 64)
+        if err != nil {
+            return nil, errors.New("max_power must be a number")
+        }
+        if minPower, ok := power["$gte"].(int64); ok && minPower > maxPower {
+            return nil, errors.New("min_power must not be greater than max_power")
+        }
+        power["$lte"] = maxPower
+    }
+    if len(power) > 0 {
+        filter["power"] = power
+    }
+    return filter, nil
+}
+
+// probePaths are the health check routes used by the container orchestration
+var probePaths = []string{"/healthz", "/readyz"}
+
+// healthz reports that the process is up
+func healthz(c *gin.Context) {
+    respond(c, http.StatusOK, gin.H{
+        "status": "ok",
+    })
+}
+
+// readyz reports whether the mongo connection is reachable
+func readyz(client *mongo.Client) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+        defer cancel()
+        if err := client.Ping(ctx, nil); err != nil {
+            ctxLogger(c).Error("readiness check failed to ping mongo", zap.Error(err))
+            respondError(c, http.StatusServiceUnavailable, "unavailable")
+            return
+        }
+        respond(c, http.StatusOK, gin.H{
+            "status": "ok",
+        })
+    }
+}
+
+// internalError logs the store failure and responds with a 500
+func internalError(c *gin.Context, err error) {
+    ctxLogger(c).Error("failed to access the aliens store", zap.String("path", c.FullPath()), zap.Error(err))
+    respondError(c, http.StatusInternalServerError, "Internal Server Error")
+}
+
+// notFound responds to the unmatched routes in the error envelope
+func notFound(c *gin.Context) {
+    respondError(c, http.StatusNotFound, "Not Found")
+}
+
+// methodNotAllowed responds to a route matched with another method in the error envelope, the
+// Allow header lists the methods registered for the path
+func methodNotAllowed(router *gin.Engine) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        c.Header("Allow", strings.Join(allowedMethods(router.Routes(), c.Request.URL.Path), ", "))
+        respondError(c, http.StatusMethodNotAllowed, "Method Not Allowed")
+    }
+}
+
+// allowedMethods returns the sorted methods of the routes matching the path, a :param matches
+// a single segment and a *param the rest of the path
+func allowedMethods(routes gin.RoutesInfo, path string) []string {
+    segments := strings.Split(strings.Trim(path, "/"), "/")
+    seen := map[string]bool{}
+    var methods []string
+    for _, route := range routes {
+        if seen[route.Method] || !routeMatches(strings.Split(strings.Trim(route.Path, "/"), "/"), segments) {
+            continue
+        }
+        seen[route.Method] = true
+        methods = append(methods, route.Method)
+    }
+    sort.Strings(methods)
+    return methods
+}
+
+func routeMatches(pattern, segments []string) bool {
+    for i, p := range pattern {
+        if strings.HasPrefix(p, "*") {
+            return true
+        }
+        if i >= len(segments) {
+            return false
+        }
+        if !strings.HasPrefix(p, ":") && p != segments[i] {
+            return false
+        }
+        if strings.HasPrefix(p, ":") && segments[i] == "" {
+            return false
+        }
+    }
+    return len(pattern) == len(segments)
+}
+
+// negotiate responds with the XML body when the client accepts XML, and with the JSON body in the envelope otherwise
+func negotiate(c *gin.Context, code int, jsonBody, xmlBody interface{}) {
+    // caches must keep the JSON and XML representations apart
+    c.Writer.Header().Add("Vary", "Accept")
+    switch c.NegotiateFormat(gin.MIMEJSON, gin.MIMEXML, gin.MIMEXML2) {
+    case gin.MIMEXML, gin.MIMEXML2:
+        c.XML(code, xmlBody)
+    default:
+        respond(c, code, jsonBody)
+    }
+}
+
+// alienOrder returns the comparison for the given sort value, or nil if it is not supported
+func alienOrder(order string) func(a, b b10alien) bool {
+    switch order {
+    case "power_asc":
+        return func(a, b b10alien) bool { return a.Power < b.Power }
+    case "power_desc":
+        return func(a, b b10alien) bool { return a.Power > b.Power }
+    case "name_asc":
+        return func(a, b b10alien) bool { return a.Name < b.Name }
+    case "name_desc":
+        return func(a, b b10alien) bool { return a.Name > b.Name }
+    }
+    return nil
 }
 
 func addB10alien(c *gin.Context) {
     var newB10alien b10alien
     
-   if err := c.ShouldBindJSON(&newB10alien); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{
-            "error":   true,
-            "message": "Bad Request",
-        })
+   if err := bindStrictJSON(c, &newB10alien); err != nil {
+        respondBindError(c, err)
+        return
+    }
+    
+    // Rejecting the empty or incomplete aliens, the decoder accepts them
+    if err := validateB10alien(newB10alien); err != nil {
+        respondError(c, http.StatusBadRequest, "Invalid alien: "+err.Error())
         return
     }
     
-    // Add the new superhero to the slice.
-    b10aliens = append(b10aliens, newB10alien)
+    if newB10alien.ID == "" {
+        newB10alien.ID = uuid.New().String()
+    }
+    newB10alien.CreatedAt = time.Now().UnixMilli()
+    newB10alien.UpdatedAt = newB10alien.CreatedAt
+    newB10alien.Version = 1
+    
+    // Add the new superhero to the store.
+    // The duplicate check is done under the same lock as the insert, so two requests can't both pass it
+    b10aliensMu.Lock()
+    defer b10aliensMu.Unlock()
+    _, err := aliens.Get(c.Request.Context(), newB10alien.ID)
+    if err == nil {
+        respondError(c, http.StatusConflict, "id already exists")
+        return
+    }
+    if !errors.Is(err, ErrAlienNotFound) {
+        internalError(c, err)
+        return
+    }
+    if err := aliens.Upsert(c.Request.Context(), newB10alien); err != nil {
+        internalError(c, err)
+        return
+    }
+    appMetrics.created.Inc()
+    emitAlienEvent(eventCreated, newB10alien)
     
     // Serializing the struct as JSON and adding it to the response
-    c.JSON(http.StatusCreated, newB10alien)
+    respond(c, http.StatusCreated, newB10alien)
+}
+
+func addB10aliensBulk(c *gin.Context) {
+    var newB10aliens []b10alien
+    
+    if err := bindStrictJSON(c, &newB10aliens); err != nil {
+        respondBindError(c, err)
+        return
+    }
+    
+    // The whole batch is rejected if any of the aliens is invalid
+    for i := range newB10aliens {
+        if err := validateB10alien(newB10aliens[i]); err != nil {
+            c.JSON(http.StatusBadRequest, envelope{
+                Data:  gin.H{"index": i},
+                Error: fmt.Sprintf("Invalid alien at index %d: %s", i, err.Error()),
+            })
+            return
+        }
+        if newB10aliens[i].ID == "" {
+            newB10aliens[i].ID = uuid.New().String()
+        }
+        newB10aliens[i].CreatedAt = time.Now().UnixMilli()
+        newB10aliens[i].UpdatedAt = newB10aliens[i].CreatedAt
+        newB10aliens[i].Version = 1
+    }
+    
+    b10aliensMu.Lock()
+    defer b10aliensMu.Unlock()
+    
+    // The IDs must be unique among the existing aliens and within the batch itself
+    ids := make([]string, 0, len(newB10aliens))
+    for _, alien := range newB10aliens {
+        ids = append(ids, alien.ID)
+    }
+    existing, err := aliens.GetAll(c.Request.Context(), bson.M{"_id": bson.M{"$in": ids}})
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    taken := map[string]bool{}
+    for _, alien := range existing {
+        taken[alien.ID] = true
+    }
+    for i, alien := range newB10aliens {
+        if taken[alien.ID] {
+            c.JSON(http.StatusConflict, envelope{
+                Data:  gin.H{"index": i},
+                Error: fmt.Sprintf("id already exists at index %d", i),
+            })
+            return
+        }
+        taken[alien.ID] = true
+    }
+    for _, alien := range newB10aliens {
+        if err := aliens.Upsert(c.Request.Context(), alien); err != nil {
+            internalError(c, err)
+            return
+        }
+        appMetrics.created.Inc()
+        emitAlienEvent(eventCreated, alien)
+    }
+    
+    respond(c, http.StatusCreated, newB10aliens)
+}
+
+// bindStrictJSON decodes the JSON body into obj like ShouldBindJSON, but rejects the fields obj doesn't have
+// so that the typos don't silently leave a field empty. The returned error is the message for the client.
+func bindStrictJSON(c *gin.Context, obj interface{}) error {
+    dec := json.NewDecoder(c.Request.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(obj); err != nil {
+        if isBodyTooLarge(err) {
+            return err
+        }
+        if field := strings.TrimPrefix(err.Error(), "json: unknown field "); field != err.Error() {
+            return errors.New("unknown field " + field)
+        }
+        return errors.New("Bad Request")
+    }
+    return nil
+}
+
+// respondBindError responds to a body which couldn't be bound, the bodies over the size limit are
+// rejected with 413 and the others with 400
+func respondBindError(c *gin.Context, err error) {
+    if isBodyTooLarge(err) {
+        respondError(c, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+        return
+    }
+    respondError(c, http.StatusBadRequest, err.Error())
+}
+
+// validateB10alien returns an error describing the first invalid field of the alien
+func validateB10alien(alien b10alien) error {
+    if alien.Name == "" {
+        return errors.New("name is required")
+    }
+    if alien.Special == "" {
+        return errors.New("special is required")
+    }
+    // Power can be 0 (Ben is weak enough) but never negative
+    if alien.Power < 0 {
+        return errors.New("power must not be negative")
+    }
+    return nil
 }
 
 func editB10alien(c *gin.Context) {
@@ -59,56 +660,222 @@ func editB10alien(c *gin.Context) {
 // Call BindJSON to bind the received JSON to newSuperhero
     // BindJSON adds the data provided by user to newSuperhero
     // This is kind of "try catch" concept
-    if err := c.ShouldBindJSON(&editB10alien); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{
-            "error":   true,
-            "message": "Bad Request",
-        })
+    if err := bindStrictJSON(c, &editB10alien); err != nil {
+        respondBindError(c, err)
+        return
+    }
+    if err := validateB10alien(editB10alien); err != nil {
+        respondError(c, http.StatusBadRequest, "Invalid alien: "+err.Error())
         return
     }
     
-    for i, hero := range b10aliens {
-        if hero.ID == id {
-            b10aliens[i].Name = editB10alien.Name
-            b10aliens[i].Power = editB10alien.Power
-            b10aliens[i].Special = editB10alien.Special
-c.JSON(http.StatusOK, editB10alien)
-            return
-        }
+    // PUT is create-or-replace, the lock keeps the lookup and the write atomic
+    b10aliensMu.Lock()
+    defer b10aliensMu.Unlock()
+    hero, err := aliens.Get(c.Request.Context(), id)
+    if err != nil && !errors.Is(err, ErrAlienNotFound) {
+        internalError(c, err)
+        return
     }
-// If the above statement doesn't return anything, that means the id is invalid
-    c.JSON(http.StatusBadRequest, gin.H{
-        "error":   true,
-        "message": "Invalid",
-    })
+    // A deleted alien is replaced by a new one, like a missing one
+    created := err != nil || hero.Deleted
+    
+    // Replacing needs the current version, so that a client can't overwrite the changes it hasn't seen
+    if !created && !versionMatches(c, editB10alien.Version, hero) {
+        c.JSON(http.StatusConflict, envelope{
+            Data:  gin.H{"version": hero.Version},
+            Error: "version mismatch, the alien was changed since it was read",
+        })
+        return
+    }
+    now := time.Now().UnixMilli()
+    if created {
+        hero.CreatedAt = now
+    }
+    missing := err != nil
+    version := hero.Version
+    hero = b10alien{
+        ID:        id,
+        Name:      editB10alien.Name,
+        Power:     editB10alien.Power,
+        Special:   editB10alien.Special,
+        CreatedAt: hero.CreatedAt,
+        UpdatedAt: now,
+        Version:   hero.Version + 1,
+    }
+    // The stored alien is only replaced at the version it was read, on top of the lock
+    if missing {
+        err = aliens.Upsert(c.Request.Context(), hero)
+    } else {
+        err = aliens.Replace(c.Request.Context(), hero, version)
+    }
+    if errors.Is(err, ErrVersionConflict) {
+        respondError(c, http.StatusConflict, "version mismatch, the alien was changed since it was read")
+        return
+    }
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    if created {
+        appMetrics.created.Inc()
+        emitAlienEvent(eventCreated, hero)
+        respond(c, http.StatusCreated, hero)
+        return
+    }
+    emitAlienEvent(eventUpdated, hero)
+    respond(c, http.StatusOK, hero)
+}
+
+// versionMatches reports whether the If-Match header, or the body version without the header, is the
+// current version of the alien. If-Match can hold the version ("3" or 3) or the ETag of the single GET.
+func versionMatches(c *gin.Context, bodyVersion int64, current b10alien) bool {
+    ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+    if ifMatch == "" {
+        return bodyVersion == current.Version
+    }
+    if v, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64); err == nil {
+        return v == current.Version
+    }
+    etag, err := alienETag(current)
+    return err == nil && etagMatches(ifMatch, etag)
 }
 
 func removeB10alien(c *gin.Context) {
     id := c.Param("id")
-    for i, alien := range b10aliens {
-        if alien.ID == id {
-            // arr := [100, 200, 300, 400, 500]
-            // arr[:2] = [100, 200]
-            // arr[2:] = [300, 400, 500]
-            // arr[2+1:] = [400. 500]
-            // [100, 200][400, 500]
-            b10aliens = append(b10aliens[:i], b10aliens[i+1:]...) // ... is required when writing 2 slices in append function
-c.JSON(http.StatusOK, gin.H{
-                "message": "Item Deleted",
-            })
+    // The delete bumps the version, it mustn't interleave with the read-modify-write of an edit
+    b10aliensMu.Lock()
+    defer b10aliensMu.Unlock()
+    err := aliens.Delete(c.Request.Context(), id)
+    if err == nil {
+        appMetrics.deleted.Inc()
+        emitAlienEvent(eventDeleted, b10alien{ID: id})
+respond(c, http.StatusOK, gin.H{
+            "message": "Item Deleted",
+        })
+        return
+    }
+    if !errors.Is(err, ErrAlienNotFound) {
+        internalError(c, err)
+        return
+    }
+// If the above statement doesn't return anything, that means the id is invalid
+    respondError(c, http.StatusBadRequest, "Invalid")
+}
+
+func cloneB10alien(c *gin.Context) {
+    b10aliensMu.Lock()
+    defer b10aliensMu.Unlock()
+    alien, err := aliens.Get(c.Request.Context(), c.Param("id"))
+    if errors.Is(err, ErrAlienNotFound) || alien.Deleted {
+        respondError(c, http.StatusNotFound, "alien not found")
+        return
+    }
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    
+    // The copy only differs by its generated id, and by its name with "?suffix=true"
+    alien.ID = uuid.New().String()
+    if c.Query("suffix") == "true" {
+        alien.Name += " (copy)"
+    }
+    alien.CreatedAt = time.Now().UnixMilli()
+    alien.UpdatedAt = alien.CreatedAt
+    alien.Version = 1
+    if err := aliens.Upsert(c.Request.Context(), alien); err != nil {
+        internalError(c, err)
+        return
+    }
+    appMetrics.created.Inc()
+    emitAlienEvent(eventCreated, alien)
+    respond(c, http.StatusCreated, alien)
+}
+
+func removeB10aliens(c *gin.Context) {
+    // The ids are either listed in the query ("?ids=1,3,5") or sent as a JSON array
+    var ids []string
+    if q := c.Query("ids"); q != "" {
+        for _, id := range strings.Split(q, ",") {
+            if id = strings.TrimSpace(id); id != "" {
+                ids = append(ids, id)
+            }
+        }
+    } else if c.Request.ContentLength != 0 {
+        if err := c.ShouldBindJSON(&ids); err != nil {
+            if isBodyTooLarge(err) {
+                respondBindError(c, err)
+                return
+            }
+            respondError(c, http.StatusBadRequest, "Bad Request")
             return
         }
     }
-// If the above statement doesn't return anything, that means the id is invalid
-    c.JSON(http.StatusBadRequest, gin.H{
-        "error":   true,
-        "message": "Invalid",
+    if len(ids) == 0 {
+        respondError(c, http.StatusBadRequest, "ids are required")
+        return
+    }
+    
+    // A single lock for the whole batch, so the concurrent writes can't interleave with it
+    b10aliensMu.Lock()
+    defer b10aliensMu.Unlock()
+    deleted, notFound := []string{}, []string{}
+    for _, id := range ids {
+        err := aliens.Delete(c.Request.Context(), id)
+        if errors.Is(err, ErrAlienNotFound) {
+            notFound = append(notFound, id)
+            continue
+        }
+        if err != nil {
+            internalError(c, err)
+            return
+        }
+        appMetrics.deleted.Inc()
+        emitAlienEvent(eventDeleted, b10alien{ID: id})
+        deleted = append(deleted, id)
+    }
+    respond(c, http.StatusOK, gin.H{
+        "deleted":   deleted,
+        "not_found": notFound,
     })
 }
 
+func restoreB10alien(c *gin.Context) {
+    id := c.Param("id")
+    b10aliensMu.Lock()
+    defer b10aliensMu.Unlock()
+    err := aliens.Restore(c.Request.Context(), id)
+    if errors.Is(err, ErrAlienNotFound) {
+        respondError(c, http.StatusNotFound, "deleted alien not found")
+        return
+    }
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    alien, err := aliens.Get(c.Request.Context(), id)
+    if err != nil {
+        internalError(c, err)
+        return
+    }
+    // A restored alien is created again for the subscribers
+    emitAlienEvent(eventCreated, alien)
+    respond(c, http.StatusOK, alien)
+}
+
 func main() {
+    var err error
+    logger, err = zap.NewProduction()
+    if err != nil {
+        panic(err)
+    }
+    defer logger.Sync() // flushes buffer, if any
+    rand.Seed(time.Now().UnixNano())
+    
     // Keploy configurations
-    port := "8080"
+    // PORT is injected by most PaaS platforms, the same port is used by keploy and the listener
+    port := getEnv("PORT", "8080")
     keploy := keploy.New(keploy.Config{
         App: keploy.AppConfig{
             Name: "b10alien-api",
@@ -118,13 +885,179 @@ func main() {
         URL: "http://localhost:8081/api",
         },
     })
-	router := gin.Default()
+    // Mongo configurations, the connection is retried until mongo is up and the seed aliens are
+    // inserted into an empty collection
+    attempts, err := strconv.Atoi(getEnv("MONGO_CONNECT_ATTEMPTS", "5"))
+    if err != nil || attempts < 1 {
+        logger.Fatal("invalid MONGO_CONNECT_ATTEMPTS", zap.Error(err))
+    }
+    maxBackoff, err := time.ParseDuration(getEnv("MONGO_CONNECT_MAX_BACKOFF", "30s"))
+    if err != nil {
+        logger.Fatal("invalid MONGO_CONNECT_MAX_BACKOFF", zap.Error(err))
+    }
+    client, err := connectMongo(context.Background(), getEnv("MONGO_URI", "mongodb://localhost:27017"), attempts, maxBackoff)
+    if err != nil {
+        logger.Fatal("failed to connect to mongo", zap.Error(err))
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    db := client.Database(getEnv("MONGO_DB", "b10alien"))
+    aliens = NewAlienDB(kmongo.NewCollection(db.Collection(getEnv("MONGO_COLLECTION", "b10aliens"))))
+    now := time.Now().UnixMilli()
+    for i := range b10aliens {
+        b10aliens[i].CreatedAt = now
+        b10aliens[i].UpdatedAt = now
+        b10aliens[i].Version = 1
+    }
+    if err := aliens.Seed(ctx, b10aliens); err != nil {
+        logger.Fatal("failed to seed the aliens", zap.Error(err))
+    }
+    
+    // The mutations are POSTed to the comma separated WEBHOOK_URLS, each delivery is tried
+    // WEBHOOK_ATTEMPTS times with WEBHOOK_TIMEOUT per attempt
+    if urls := splitList(os.Getenv("WEBHOOK_URLS")); len(urls) > 0 {
+        for _, u := range urls {
+            if parsed, err := url.ParseRequestURI(u); err != nil || parsed.Host == "" {
+                logger.Fatal("invalid WEBHOOK_URLS", zap.String("url", u))
+            }
+        }
+        webhookTimeout, err := time.ParseDuration(getEnv("WEBHOOK_TIMEOUT", "5s"))
+        if err != nil || webhookTimeout <= 0 {
+            logger.Fatal("WEBHOOK_TIMEOUT must be a positive duration")
+        }
+        webhookAttempts, err := strconv.Atoi(getEnv("WEBHOOK_ATTEMPTS", "3"))
+        if err != nil || webhookAttempts < 1 {
+            logger.Fatal("WEBHOOK_ATTEMPTS must be a positive integer")
+        }
+        webhooks = newWebhookDispatcher(urls, webhookTimeout, webhookAttempts)
+    }
+    
+    // Same as gin.Default() but with structured logs which skip the probes, tagged with the request id.
+    // The id middleware runs before keploy, so the recorded test cases and the keploy server get the id too
+    // The request bodies are capped at MAX_BODY_BYTES before keploy reads them
+    maxBody, err := strconv.ParseInt(getEnv("MAX_BODY_BYTES", "1048576"), 10, 64)
+    if err != nil || maxBody < 1 {
+        logger.Fatal("MAX_BODY_BYTES must be a positive integer")
+    }
+    // The handlers get REQUEST_TIMEOUT to respond, the slower requests are answered with 504.
+    // The event streams are long lived, they are closed by the clients
+    timeout, err := time.ParseDuration(getEnv("REQUEST_TIMEOUT", "10s"))
+    if err != nil || timeout <= 0 {
+        logger.Fatal("REQUEST_TIMEOUT must be a positive duration")
+    }
+    router := gin.New()
+    // The client IP of the logs and of the rate limits is only read from X-Forwarded-For when the request
+    // comes from one of the TRUSTED_PROXIES (comma separated IPs or CIDRs), by default the header is ignored
+    if err := router.SetTrustedProxies(splitList(getEnv("TRUSTED_PROXIES", ""))); err != nil {
+        logger.Fatal("invalid TRUSTED_PROXIES", zap.Error(err))
+    }
+    router.Use(requestIDMiddleware(), requestLogger(logger, probePaths), recoveryMiddleware(), appMetrics.middleware(), bodyLimitMiddleware(maxBody))
+    // The probes are registered before the other middlewares, so they aren't captured by keploy or rate limited
+    router.GET("/healthz", healthz)
+    router.GET("/readyz", readyz(client))
+    router.GET("/metrics", appMetrics.handler())
     kgin.GinV1(keploy, router)
+    // The allowed origin defaults to any, production should set CORS_ALLOWED_ORIGIN
+    router.Use(corsMiddleware(getEnv("CORS_ALLOWED_ORIGIN", "*")))
+    
+    // Rate limiting per client IP, RATE_LIMIT_RPS requests per second with bursts of RATE_LIMIT_BURST
+    rps, err := strconv.ParseFloat(getEnv("RATE_LIMIT_RPS", "10"), 64)
+    if err != nil || rps <= 0 {
+        logger.Fatal("RATE_LIMIT_RPS must be a positive number")
+    }
+    burst, err := strconv.Atoi(getEnv("RATE_LIMIT_BURST", "20"))
+    if err != nil || burst < 1 {
+        logger.Fatal("RATE_LIMIT_BURST must be a positive integer")
+    }
+    router.Use(rateLimitMiddleware(newRateLimiter(rps, burst, 10*time.Minute)))
+
+    // The writes need a bearer token signed with JWT_SECRET, or one of the API_KEYS or of the keys in
+    // API_KEYS_FILE for the machine to machine callers. The reads are public
+    apiKeys, err := loadAPIKeys(os.Getenv("API_KEYS"), os.Getenv("API_KEYS_FILE"))
+    if err != nil {
+        logger.Fatal("failed to read API_KEYS_FILE", zap.Error(err))
+    }
+    auth, err := writeAuth(os.Getenv("JWT_SECRET"), apiKeys)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+
+    // The creates sent with an Idempotency-Key are replayed for IDEMPOTENCY_KEY_TTL, the store is
+    // shared by the versions so that a retry on either route is replayed
+    idempotencyTTL, err := time.ParseDuration(getEnv("IDEMPOTENCY_KEY_TTL", "24h"))
+    if err != nil || idempotencyTTL <= 0 {
+        logger.Fatal("IDEMPOTENCY_KEY_TTL must be a positive duration")
+    }
+    idempotency := idempotencyMiddleware(newIdempotencyStore(idempotencyTTL))
 
     router.GET("/", home)
-	router.GET("/b10aliens", getB10aliens)
-	router.POST("/b10aliens", addB10alien)
-	router.PUT("/b10aliens/:id", editB10alien)
-    router.DELETE("/b10aliens/:id", removeB10alien)
-    router.Run(":8080")
+    // The unmatched routes and methods get the error envelope too, instead of the plain text of gin
+    router.HandleMethodNotAllowed = true
+    router.NoRoute(notFound)
+    router.NoMethod(methodNotAllowed(router))
+    registerB10alienRoutes(router.Group("/v1"), auth, idempotency)
+    // The unversioned routes are kept for the existing integrations
+    registerB10alienRoutes(router.Group("", deprecatedMiddleware("/v1")), auth, idempotency)
+    
+    // Serving in the background so the shutdown signals can be handled, the timeout wraps the whole
+    // router so that a timed out request is answered while its handler still runs
+    server := &http.Server{
+        Addr:    ":" + port,
+        Handler: timeoutHandler(router, timeout, streamPaths),
+    }
+    go func() {
+        if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+            logger.Fatal("failed to start the server", zap.Error(err))
+        }
+    }()
+    
+    quit := make(chan os.Signal, 1)
+    signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+    sig := <-quit
+    logger.Info("shutting down the server", zap.String("signal", sig.String()))
+    
+    // In-flight requests get 10 seconds to complete before the connections are closed
+    shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer shutdownCancel()
+    if err := server.Shutdown(shutdownCtx); err != nil {
+        logger.Error("failed to shutdown the server gracefully", zap.Error(err))
+    }
+    logger.Info("server stopped, disconnecting from mongo")
+    if err := client.Disconnect(shutdownCtx); err != nil {
+        logger.Error("failed to disconnect from mongo", zap.Error(err))
+    }
+    logger.Info("shutdown complete")
+}
+
+// registerB10alienRoutes adds the alien routes to the given group, the mutating routes are behind auth.
+// The creates also go through the idempotency middleware.
+func registerB10alienRoutes(r *gin.RouterGroup, auth, idempotency gin.HandlerFunc) {
+    r.GET("/b10aliens", getB10aliens)
+    r.HEAD("/b10aliens", headB10aliens)
+    r.GET("/b10aliens/count", countB10aliens)
+    r.GET("/b10aliens/export.csv", exportB10aliens)
+    r.GET("/b10aliens/search", searchB10aliens)
+    r.GET("/b10aliens/strongest", strongestB10alien)
+    r.GET("/b10aliens/weakest", weakestB10alien)
+    r.GET("/b10aliens/random", randomB10alien)
+    r.GET("/b10aliens/schema", schemaB10alien)
+    r.GET("/b10aliens/stream", streamB10aliens)
+    r.GET("/b10aliens/:id", getB10alien)
+    
+    w := r.Group("", auth)
+    w.POST("/b10aliens", idempotency, addB10alien)
+    w.POST("/b10aliens/bulk", addB10aliensBulk)
+    w.PUT("/b10aliens/:id", editB10alien)
+    w.DELETE("/b10aliens", removeB10aliens)
+    w.DELETE("/b10aliens/:id", removeB10alien)
+    w.POST("/b10aliens/:id/restore", restoreB10alien)
+    w.POST("/b10aliens/:id/clone", cloneB10alien)
+}
+
+// getEnv returns the value of the environment variable, or def if it is not set
+func getEnv(key, def string) string {
+    if v, ok := os.LookupEnv(key); ok && v != "" {
+        return v
+    }
+    return def
 }
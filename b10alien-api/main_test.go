@@ -1,11 +1,65 @@
 package main
 
 import (
+    "github.com/gin-gonic/gin"
     "github.com/keploy/go-sdk/keploy"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
     "testing"
+    "time"
 )
 func TestKeploy(t *testing.T) {
+    // the seed aliens are inserted into mongo and the testcases are fetched from the keploy server
+    mongoURI, err := url.Parse(getEnv("MONGO_URI", "mongodb://localhost:27017"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    mongoHost, _, _ := strings.Cut(mongoURI.Host, ",")
+    for _, addr := range []string{mongoHost, "localhost:8081"} {
+        conn, err := net.DialTimeout("tcp", addr, time.Second)
+        if err != nil {
+            t.Skipf("%v isn't reachable: %v", addr, err)
+        }
+        conn.Close()
+    }
+    // main can't start without a way to authenticate the writes
+    if getEnv("JWT_SECRET", "") == "" {
+        t.Setenv("JWT_SECRET", "keploy-test-secret")
+    }
     keploy.SetTestMode()
     go main()
     keploy.AssertTests(t)
-}
\ No newline at end of file
+}
+
+func TestFieldsXML(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+    r := gin.New()
+    r.GET("/b10aliens", func(c *gin.Context) {
+        list := []b10alien{{ID: "3", Name: "Xlr8", Power: 1500, Special: "speed,mobility"}}
+        keep := fieldSet(strings.Split(c.Query("fields"), ","))
+        selected, err := selectFields(list, keep)
+        if err != nil {
+            t.Fatal(err)
+        }
+        negotiate(c, http.StatusOK, selected, b10alienFieldList{Aliens: list, Fields: keep})
+    })
+
+    for _, tt := range []struct {
+        accept string
+        want   string
+    }{
+        {accept: gin.MIMEXML, want: `<b10aliens><b10alien><id>3</id><name>Xlr8</name></b10alien></b10aliens>`},
+        {accept: gin.MIMEJSON, want: `{"success":true,"data":[{"id":"3","name":"Xlr8"}],"error":null}`},
+    } {
+        req := httptest.NewRequest(http.MethodGet, "/b10aliens?fields=name,id", nil)
+        req.Header.Set("Accept", tt.accept)
+        w := httptest.NewRecorder()
+        r.ServeHTTP(w, req)
+        if body := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || body != tt.want {
+            t.Errorf("%s: got %v %v, want %v", tt.accept, w.Code, body, tt.want)
+        }
+    }
+}
\ No newline at end of file
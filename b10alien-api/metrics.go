@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by route, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		created: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "b10aliens_created_total",
+			Help: "Number of aliens created.",
+		}),
+		deleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "b10aliens_deleted_total",
+			Help: "Number of aliens deleted.",
+		}),
+	}
+}
+
+// metrics is the prometheus collector of the API.
+type metrics struct {
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	created  prometheus.Counter
+	deleted  prometheus.Counter
+}
+
+func (m *metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.latency.Describe(ch)
+	m.created.Describe(ch)
+	m.deleted.Describe(ch)
+}
+
+func (m *metrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.latency.Collect(ch)
+	m.created.Collect(ch)
+	m.deleted.Collect(ch)
+}
+
+// middleware records the count and latency of every request. The route template is used
+// as the label instead of the path, so that the ids don't blow up the cardinality.
+func (m *metrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		m.requests.WithLabelValues(route, c.Request.Method, status).Inc()
+		m.latency.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// handler serves the metrics of the API along with the go runtime and process metrics.
+func (m *metrics) handler() gin.HandlerFunc {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m, prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	return gin.WrapH(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+}
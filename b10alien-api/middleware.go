@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// corsAllowedMethods lists the methods used by the alien routes.
+var corsAllowedMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// corsMiddleware sets the CORS headers for the given origin and answers the preflight requests.
+func corsMiddleware(origin string) gin.HandlerFunc {
+	methods := strings.Join(corsAllowedMethods, ", ")
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Methods", methods)
+		h.Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, If-Match, If-None-Match, "+requestIDHeader+", "+idempotencyKeyHeader+", "+apiKeyHeader)
+		h.Set("Access-Control-Expose-Headers", "ETag, X-Total-Count, "+requestIDHeader+", "+idempotentReplayedHeader)
+		if origin != "*" {
+			// the response differs per origin, so caches must not share it
+			h.Add("Vary", "Origin")
+		}
+
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+// deprecatedMiddleware marks the response of an unversioned route as deprecated and logs a warning,
+// pointing the clients to the same route under the successor version prefix.
+func deprecatedMiddleware(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctxLogger(c).Warn("deprecated route called", zap.String("method", c.Request.Method), zap.String("route", c.FullPath()), zap.String("successor", successor+c.FullPath()))
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+c.Request.URL.Path+">; rel=\"successor-version\"")
+		c.Next()
+	}
+}
+
+// requestLogger logs every request as a structured entry, except for the skipped paths.
+func requestLogger(log *zap.Logger, skipPaths []string) gin.HandlerFunc {
+	skip := map[string]bool{}
+	for _, p := range skipPaths {
+		skip[p] = true
+	}
+	return func(c *gin.Context) {
+		if skip[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		c.Next()
+		fields := []zap.Field{
+			zap.String("request_id", c.GetString(requestIDKey)),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", c.Writer.Status()),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("client_ip", c.ClientIP()),
+		}
+		// the subject is only known for the authenticated requests
+		if sub := c.GetString(subjectKey); sub != "" {
+			fields = append(fields, zap.String("subject", sub))
+		}
+		log.Info("request", fields...)
+	}
+}
+
+// bodyLimitMiddleware rejects the request bodies larger than limit bytes with 413. The declared
+// length is checked upfront, the chunked bodies are cut by http.MaxBytesReader while they are read
+// so the handlers have to report the error of the read, see isBodyTooLarge.
+func bodyLimitMiddleware(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			abortWithError(c, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// isBodyTooLarge reports whether the error is returned by a read past the body limit
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// timeoutWriter buffers the response of a handler running under timeoutHandler, it is only
+// written out when the handler completes in time. The writes after the timeout fail.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	h           http.Header
+	buf         bytes.Buffer
+	code        int
+	wroteHeader bool
+	err         error
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.h
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil && !w.wroteHeader {
+		w.code, w.wroteHeader = code, true
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	if !w.wroteHeader {
+		w.code, w.wroteHeader = http.StatusOK, true
+	}
+	return w.buf.Write(b)
+}
+
+// timeoutHandler bounds the requests to the timeout, except for the skipped paths. Like
+// http.TimeoutHandler, the handler runs in its own goroutine with the request context cancelled on
+// the deadline, and the client gets a 504 in the error envelope as soon as the deadline is exceeded,
+// even when the handler ignores the context. The request is served out of gin's middlewares, so
+// that the gin context isn't reused while a timed out handler still runs.
+func timeoutHandler(h http.Handler, timeout time.Duration, skipPaths []string) http.Handler {
+	skip := map[string]bool{}
+	for _, p := range skipPaths {
+		skip[p] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skip[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		tw := &timeoutWriter{h: http.Header{}}
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			h.ServeHTTP(tw, r)
+			close(done)
+		}()
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			dst := w.Header()
+			for k, v := range tw.h {
+				dst[k] = v
+			}
+			if !tw.wroteHeader {
+				tw.code = http.StatusOK
+			}
+			w.WriteHeader(tw.code)
+			w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				logger.Warn("request timed out", zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.Duration("timeout", timeout))
+				w.WriteHeader(http.StatusGatewayTimeout)
+				render.JSON{Data: envelope{Error: "Gateway Timeout"}}.Render(w)
+				tw.err = http.ErrHandlerTimeout
+				return
+			}
+			// the client is gone, nothing can be sent anymore
+			tw.err = ctx.Err()
+		}
+	})
+}
+
+// recoveryMiddleware responds to a panicking handler with a 500 in the error envelope, the panic
+// and its stack are logged but never sent to the client.
+func recoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// the server aborts the response on purpose, eg: when the client is gone
+				panic(p)
+			}
+			ctxLogger(c).Error("handler panicked", zap.String("method", c.Request.Method), zap.String("path", c.Request.URL.Path), zap.Any("panic", p), zap.Stack("stack"))
+			if c.Writer.Written() {
+				// the response can't be replaced anymore
+				c.Abort()
+				return
+			}
+			abortWithError(c, http.StatusInternalServerError, "Internal Server Error")
+		}()
+		c.Next()
+	}
+}
+
+// requestIDHeader carries the id of the request, it is generated when the client doesn't send one
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey and loggerKey are the context keys of the request id and of the logger tagged with it
+const (
+	requestIDKey = "request_id"
+	loggerKey    = "logger"
+)
+
+// requestIDMiddleware reads or generates the id of the request and echoes it in the response.
+// The generated id is also set on the request, so that keploy captures and replays it.
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if id == "" {
+			id = uuid.New().String()
+			c.Request.Header.Set(requestIDHeader, id)
+		}
+		c.Set(requestIDKey, id)
+		c.Set(loggerKey, logger.With(zap.String("request_id", id)))
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// ctxLogger returns the logger of the request, tagged with its id
+func ctxLogger(c *gin.Context) *zap.Logger {
+	if l, ok := c.Value(loggerKey).(*zap.Logger); ok {
+		return l
+	}
+	return logger
+}
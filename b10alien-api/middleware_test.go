@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(bodyLimitMiddleware(32))
+	r.POST("/b10aliens", func(c *gin.Context) {
+		var alien b10alien
+		if err := bindStrictJSON(c, &alien); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		respond(c, http.StatusOK, alien)
+	})
+
+	small := `{"name": "Xlr8"}`
+	large := `{"name": "` + strings.Repeat("x", 64) + `"}`
+	for _, tt := range []struct {
+		name    string
+		body    string
+		chunked bool
+		status  int
+	}{
+		{name: "within limit", body: small, status: http.StatusOK},
+		{name: "oversized", body: large, status: http.StatusRequestEntityTooLarge},
+		// the length isn't declared, so the body is only cut while it is decoded
+		{name: "oversized chunked", body: large, chunked: true, status: http.StatusRequestEntityTooLarge},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/b10aliens", strings.NewReader(tt.body))
+		if tt.chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: got %v, want %v: %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	release := make(chan struct{})
+	defer close(release)
+	r := gin.New()
+	// the handler ignores the context, eg: when it waits for b10aliensMu
+	r.GET("/blocked", func(c *gin.Context) {
+		<-release
+		respond(c, http.StatusOK, "late")
+	})
+	r.GET("/fast", func(c *gin.Context) {
+		c.Header("ETag", `"1"`)
+		respond(c, http.StatusOK, "ok")
+	})
+	h := timeoutHandler(r, 50*time.Millisecond, nil)
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/blocked", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("got %v, want %v", w.Code, http.StatusGatewayTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("got a response after %v, want it on the timeout", elapsed)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Gateway Timeout") {
+		t.Errorf("got %v, want the timeout error", body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` {
+		t.Errorf("got %v %v, want %v with the handler headers", w.Code, w.Header(), http.StatusOK)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bucket is the token bucket of a single client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rps float64, burst int, idle time.Duration) *rateLimiter {
+	l := &rateLimiter{
+		rate:    rps,
+		burst:   float64(burst),
+		idle:    idle,
+		buckets: map[string]*bucket{},
+	}
+	go func() {
+		for now := range time.Tick(idle) {
+			l.cleanup(now)
+		}
+	}()
+	return l
+}
+
+// rateLimiter is a token bucket rate limiter keyed by client. Each bucket holds up to burst
+// tokens and is refilled with rate tokens per second, every request takes one token.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	idle    time.Duration
+	buckets map[string]*bucket
+}
+
+// allow takes a token from the bucket of the key. If the bucket is empty it returns false
+// along with the time after which a token will be available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// cleanup removes the buckets of the clients that haven't made a request for the idle duration.
+func (l *rateLimiter) cleanup(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > l.idle {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// rateLimitMiddleware rejects the requests of clients exceeding the rate limit.
+func rateLimitMiddleware(l *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, wait := l.allow(c.ClientIP(), time.Now())
+		if !ok {
+			// Retry-After is in whole seconds, so round up
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			abortWithError(c, http.StatusTooManyRequests, "Too Many Requests")
+			return
+		}
+		c.Next()
+	}
+}
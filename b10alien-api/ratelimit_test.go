@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tt := range []struct {
+		name    string
+		proxies []string
+		status  int
+	}{
+		// a client can't get a fresh bucket by sending another X-Forwarded-For
+		{name: "no trusted proxy", status: http.StatusTooManyRequests},
+		// behind a trusted proxy every forwarded client has its own bucket
+		{name: "trusted proxy", proxies: []string{"10.0.0.1"}, status: http.StatusOK},
+	} {
+		r := gin.New()
+		if err := r.SetTrustedProxies(tt.proxies); err != nil {
+			t.Fatal(err)
+		}
+		r.Use(rateLimitMiddleware(newRateLimiter(1, 1, time.Minute)))
+		r.GET("/b10aliens", func(c *gin.Context) {
+			respond(c, http.StatusOK, "ok")
+		})
+
+		var code int
+		for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+			req := httptest.NewRequest(http.MethodGet, "/b10aliens", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", ip)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			code = w.Code
+		}
+		if code != tt.status {
+			t.Errorf("%s: got %v, want %v", tt.name, code, tt.status)
+		}
+	}
+}
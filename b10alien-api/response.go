@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// envelope is the shape of every JSON response, so that the clients parse the successes and
+// the errors the same way. Error is null on success and holds the message otherwise.
+type envelope struct {
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data"`
+	Error   interface{} `json:"error"`
+}
+
+// respond writes the data in a successful envelope
+func respond(c *gin.Context, code int, data interface{}) {
+	c.JSON(code, envelope{Success: true, Data: data})
+}
+
+// respondError writes the message in a failed envelope
+func respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, envelope{Error: message})
+}
+
+// abortWithError is respondError for the middlewares, the remaining handlers are skipped
+func abortWithError(c *gin.Context, code int, message string) {
+	c.AbortWithStatusJSON(code, envelope{Error: message})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonSchemaVersion is the dialect of the generated schemas
+const jsonSchemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+// b10alienSchema describes the alien model, it is generated from the struct so that it can't drift
+var b10alienSchema = objectSchema(reflect.TypeOf(b10alien{}), "b10alien")
+
+// objectSchema returns the JSON Schema of a struct. The properties are named by the json tags and
+// the schema tags add the constraints, eg: `schema:"required,minimum=0"`. The unknown fields are
+// rejected, the same as bindStrictJSON does.
+func objectSchema(t reflect.Type, title string) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		prop := typeSchema(f.Type)
+		for _, opt := range strings.Split(f.Tag.Get("schema"), ",") {
+			key, value, _ := strings.Cut(opt, "=")
+			switch key {
+			case "required":
+				required = append(required, name)
+			case "readonly":
+				// set by the server, the client values are ignored
+				prop["readOnly"] = true
+			case "minimum":
+				if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+					prop["minimum"] = n
+				}
+			}
+		}
+		properties[name] = prop
+	}
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+	if title != "" {
+		schema["$schema"] = jsonSchemaVersion
+		schema["title"] = title
+	}
+	return schema
+}
+
+// typeSchema returns the JSON Schema of a field type.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return objectSchema(t, "")
+	}
+	// eg: an interface, any value is valid
+	return map[string]interface{}{}
+}
+
+func schemaB10alien(c *gin.Context) {
+	respond(c, http.StatusOK, b10alienSchema)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// streamPaths are the long lived event stream routes, they aren't bound by the request timeout
+var streamPaths = []string{"/b10aliens/stream", "/v1/b10aliens/stream"}
+
+// streamBufferSize is the number of events a subscriber can lag behind before being dropped
+const streamBufferSize = 16
+
+// streamKeepAlive is the interval of the comments keeping the idle streams open through the proxies
+const streamKeepAlive = 30 * time.Second
+
+// alienEvents fans the alien events out to the streams
+var alienEvents = newEventBroker()
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subscribers: map[chan alienEvent]bool{}}
+}
+
+// eventBroker is an in-process pub/sub of the alien events, every subscriber has its own channel.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan alienEvent]bool
+}
+
+// subscribe returns the channel of a new subscriber and the func removing it. The channel is closed
+// when the subscriber is removed, or dropped for lagging behind.
+func (b *eventBroker) subscribe() (<-chan alienEvent, func()) {
+	ch := make(chan alienEvent, streamBufferSize)
+	b.mu.Lock()
+	b.subscribers[ch] = true
+	b.mu.Unlock()
+	return ch, func() { b.remove(ch) }
+}
+
+func (b *eventBroker) remove(ch chan alienEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[ch] {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish sends the event to every subscriber without blocking. A subscriber whose buffer is full is
+// dropped, so that a slow client can't hold the mutations back, it has to reconnect.
+func (b *eventBroker) publish(e alienEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			logger.Warn("dropping a lagging event stream subscriber", zap.String("type", e.Type), zap.String("id", e.Alien.ID))
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// streamB10aliens holds a Server-Sent Events stream of the alien events, every event is a frame
+// named by its type with the changed alien as data. The subscriber is removed when the client is gone.
+func streamB10aliens(c *gin.Context) {
+	events, unsubscribe := alienEvents.subscribe()
+	defer unsubscribe()
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// the reverse proxies mustn't buffer the frames
+	h.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e.Alien)
+			if err != nil {
+				ctxLogger(c).Error("failed to encode the stream event", zap.String("type", e.Type), zap.Error(err))
+				continue
+			}
+			if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
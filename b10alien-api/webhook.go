@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The types of the alien events
+const (
+	eventCreated = "created"
+	eventUpdated = "updated"
+	eventDeleted = "deleted"
+)
+
+// webhookInitialBackoff is the wait before the second delivery attempt, it doubles on every attempt.
+const webhookInitialBackoff = time.Second
+
+// alienEvent is sent after a successful mutation. The alien of a deleted event only holds its id.
+type alienEvent struct {
+	Type  string   `json:"type"`
+	Alien b10alien `json:"alien"`
+}
+
+// webhooks delivers the alien events, it is set up in main and nil when no webhook is configured
+var webhooks *webhookDispatcher
+
+// emitAlienEvent notifies the streams and the webhooks of a mutated alien, without waiting for them.
+func emitAlienEvent(eventType string, alien b10alien) {
+	e := alienEvent{Type: eventType, Alien: alien}
+	alienEvents.publish(e)
+	webhooks.dispatch(e)
+}
+
+func newWebhookDispatcher(urls []string, timeout time.Duration, attempts int) *webhookDispatcher {
+	return &webhookDispatcher{
+		urls:     urls,
+		client:   &http.Client{Timeout: timeout},
+		attempts: attempts,
+	}
+}
+
+// webhookDispatcher POSTs the events to every webhook url. A failed delivery is retried up to
+// attempts times with an exponential backoff, and only logged when it keeps failing.
+type webhookDispatcher struct {
+	urls     []string
+	client   *http.Client
+	attempts int
+}
+
+// dispatch delivers the event in the background, the request which emitted it isn't delayed.
+func (d *webhookDispatcher) dispatch(e alienEvent) {
+	if d == nil {
+		return
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		logger.Error("failed to encode the webhook event", zap.String("type", e.Type), zap.Error(err))
+		return
+	}
+	for _, url := range d.urls {
+		go d.deliver(url, e.Type, body)
+	}
+}
+
+func (d *webhookDispatcher) deliver(url, eventType string, body []byte) {
+	backoff := webhookInitialBackoff
+	for i := 1; ; i++ {
+		err := d.post(url, body)
+		if err == nil {
+			return
+		}
+		if i >= d.attempts {
+			logger.Error("failed to deliver the webhook event", zap.String("url", url), zap.String("type", eventType), zap.Int("attempts", i), zap.Error(err))
+			return
+		}
+		logger.Warn("failed to deliver the webhook event, retrying", zap.String("url", url), zap.String("type", eventType), zap.Int("attempt", i), zap.Duration("backoff", backoff), zap.Error(err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends the event once, the non 2xx responses are failures
+func (d *webhookDispatcher) post(url string, body []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// splitList returns the non blank items of a comma separated list
+func splitList(list string) []string {
+	var items []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}